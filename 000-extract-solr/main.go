@@ -0,0 +1,540 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SolrResponse struct {
+	ResponseHeader struct {
+		Status int `json:"status"`
+	} `json:"responseHeader"`
+	Response struct {
+		NumFound int               `json:"numFound"`
+		Start    int               `json:"start"`
+		Docs     []json.RawMessage `json:"docs"`
+	} `json:"response"`
+	NextCursorMark string `json:"nextCursorMark"`
+	Error          *struct {
+		Msg string `json:"msg"`
+	} `json:"error"`
+}
+
+// crawlState is saved after every completed page
+// so an interrupted crawl can pick up where it left off
+type crawlState struct {
+	Rows       int    `json:"rows"`
+	NumFound   int    `json:"numFound"`
+	Offset     int    `json:"offset"`
+	CursorMark string `json:"cursorMark"`
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "crawl":
+		crawl(os.Args[2:])
+	case "trim":
+		trim(os.Args[2:])
+	case "csv":
+		// the input.csv 011-i7-export-transform reads
+		// any flags passed override these defaults
+		trim(append([]string{"-config", "input-csv.json", "-format", "csv", "-o", "input.csv"}, os.Args[2:]...))
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Println("Usage: go run main.go crawl|trim|csv [flags]")
+	os.Exit(1)
+}
+
+func crawl(args []string) {
+	fs := flag.NewFlagSet("crawl", flag.ExitOnError)
+	solr := fs.String("solr", "http://localhost:8080/solr/collection1", "base URL of the i7 solr core")
+	query := fs.String("q", "*:*", "solr query")
+	rows := fs.Int("rows", 100, "documents per page")
+	dir := fs.String("dir", "output", "directory to write solr.$OFFSET.json pages to")
+	statePath := fs.String("state", "crawl-state.json", "file to record crawl progress in")
+	sortClause := fs.String("sort", "PID asc", "sort clause, must include the uniqueKey when paging with cursorMark")
+	useCursor := fs.Bool("cursor", true, "page with cursorMark (solr 4.7+), otherwise page with start")
+	retries := fs.Int("retries", 5, "attempts per page before giving up")
+	delay := fs.Duration("delay", time.Second, "pause between pages")
+	fs.Parse(args)
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		log.Fatalf("Unable to create %s: %v", *dir, err)
+	}
+
+	c := crawler{
+		solr:    *solr,
+		query:   *query,
+		rows:    *rows,
+		sort:    *sortClause,
+		retries: *retries,
+	}
+
+	state, err := loadState(*statePath)
+	if err != nil {
+		log.Fatalf("Unable to read %s: %v", *statePath, err)
+	}
+	if state.Rows != 0 && state.Rows != *rows {
+		log.Fatalf("%s was written with -rows %d, remove it to start over with -rows %d", *statePath, state.Rows, *rows)
+	}
+	state.Rows = *rows
+	if state.CursorMark == "" {
+		state.CursorMark = "*"
+	}
+
+	numFound, err := c.numFound()
+	if err != nil {
+		log.Fatalf("Unable to get the number of documents from solr: %v", err)
+	}
+	state.NumFound = numFound
+	log.Printf("Found %d documents, resuming at offset %d", numFound, state.Offset)
+
+	for state.Offset < state.NumFound {
+		path := filepath.Join(*dir, fmt.Sprintf("solr.%d.json", state.Offset))
+
+		// without a cursor every page can be fetched on its own
+		// so anything already on disk is done
+		if !*useCursor {
+			if _, err := os.Stat(path); err == nil {
+				state.Offset += *rows
+				continue
+			}
+		}
+
+		params := c.params()
+		if *useCursor {
+			params.Set("cursorMark", state.CursorMark)
+		} else {
+			params.Set("start", fmt.Sprint(state.Offset))
+		}
+
+		log.Printf("Fetching offset %d of %d", state.Offset, state.NumFound)
+		body, resp, err := c.fetch(params)
+		if err != nil {
+			log.Fatalf("Giving up on offset %d: %v", state.Offset, err)
+		}
+
+		if err := writeFileAtomic(path, body); err != nil {
+			log.Fatalf("Unable to write %s: %v", path, err)
+		}
+
+		state.Offset += *rows
+		state.NumFound = resp.Response.NumFound
+		if *useCursor {
+			if resp.NextCursorMark == state.CursorMark {
+				// solr returns the same cursor once there is nothing left
+				state.Offset = state.NumFound
+			}
+			state.CursorMark = resp.NextCursorMark
+		}
+		if err := saveState(*statePath, state); err != nil {
+			log.Fatalf("Unable to save %s: %v", *statePath, err)
+		}
+
+		time.Sleep(*delay)
+	}
+
+	fmt.Println("Solr crawl complete. Output written to", *dir)
+}
+
+type crawler struct {
+	solr    string
+	query   string
+	rows    int
+	sort    string
+	retries int
+}
+
+func (c crawler) params() url.Values {
+	params := url.Values{}
+	params.Set("q", c.query)
+	params.Set("rows", fmt.Sprint(c.rows))
+	params.Set("sort", c.sort)
+	params.Set("wt", "json")
+	params.Set("indent", "true")
+
+	return params
+}
+
+func (c crawler) numFound() (int, error) {
+	params := c.params()
+	params.Set("rows", "0")
+	_, resp, err := c.fetch(params)
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.Response.NumFound, nil
+}
+
+// fetch a page from solr, retrying with a backoff
+// until solr hands back a parseable response
+func (c crawler) fetch(params url.Values) ([]byte, SolrResponse, error) {
+	url := fmt.Sprintf("%s/select?%s", c.solr, params.Encode())
+	backoff := time.Second
+
+	var err error
+	for attempt := 1; attempt <= c.retries; attempt++ {
+		var body []byte
+		var resp SolrResponse
+		body, resp, err = c.get(url)
+		if err == nil {
+			return body, resp, nil
+		}
+
+		log.Printf("Attempt %d/%d for %s failed: %v", attempt, c.retries, url, err)
+		if attempt < c.retries {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	return nil, SolrResponse{}, err
+}
+
+func (c crawler) get(url string) ([]byte, SolrResponse, error) {
+	var r SolrResponse
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, r, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, r, err
+	}
+
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, r, fmt.Errorf("invalid JSON (HTTP %d): %v", resp.StatusCode, err)
+	}
+	if r.Error != nil {
+		return nil, r, fmt.Errorf("solr error (HTTP %d): %s", resp.StatusCode, r.Error.Msg)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, r, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	return body, r, nil
+}
+
+func loadState(path string) (crawlState, error) {
+	var state crawlState
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+func saveState(path string, state crawlState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic makes sure a crash never leaves a half written page behind
+func writeFileAtomic(path string, data []byte) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// fieldConfig decides which solr fields survive the trim
+// include and exclude are glob patterns, i.e. "mods_*_mt"
+// columns is the ordered CSV header
+type fieldConfig struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+	Columns []string `json:"columns"`
+}
+
+func loadFieldConfig(f string) (fieldConfig, error) {
+	var config fieldConfig
+
+	data, err := os.ReadFile(f)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, err
+	}
+
+	for _, pattern := range append(config.Include, config.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return config, fmt.Errorf("bad field pattern %q: %v", pattern, err)
+		}
+	}
+
+	return config, nil
+}
+
+func (c fieldConfig) keep(field string) bool {
+	if matchAny(field, c.Exclude) {
+		return false
+	}
+
+	return len(c.Include) == 0 || matchAny(field, c.Include)
+}
+
+func matchAny(field string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, field); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func trim(args []string) {
+	fs := flag.NewFlagSet("trim", flag.ExitOnError)
+	dir := fs.String("dir", "output", "directory of solr.$OFFSET.json pages written by crawl")
+	configPath := fs.String("config", "fields.json", "field projection config")
+	format := fs.String("format", "json", "output format: json, ndjson or csv")
+	outputPath := fs.String("o", "", "output file (default all.$FORMAT)")
+	fs.Parse(args)
+
+	config, err := loadFieldConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Unable to load %s: %v", *configPath, err)
+	}
+
+	if *outputPath == "" {
+		*outputPath = "all." + *format
+	}
+
+	var w docWriter
+	switch *format {
+	case "json", "ndjson":
+		w = &jsonWriter{array: *format == "json"}
+	case "csv":
+		if len(config.Columns) == 0 {
+			log.Fatalf("%s needs a list of columns to write CSV", *configPath)
+		}
+		w = &csvWriter{columns: config.Columns}
+	default:
+		log.Fatalf("Unknown format %s", *format)
+	}
+
+	pages, err := pagePaths(*dir)
+	if err != nil {
+		log.Fatalf("Unable to list %s: %v", *dir, err)
+	}
+
+	outputFile, err := os.Create(*outputPath)
+	if err != nil {
+		log.Fatalf("Error creating output file: %v", err)
+	}
+	defer outputFile.Close()
+
+	out := bufio.NewWriter(outputFile)
+	if err := w.Begin(out); err != nil {
+		log.Fatalf("Error writing %s: %v", *outputPath, err)
+	}
+
+	total := 0
+	for _, page := range pages {
+		docs, err := readDocs(page)
+		if err != nil {
+			log.Fatalf("Unable to read %s: %v", page, err)
+		}
+
+		for _, doc := range docs {
+			trimmed := map[string]interface{}{}
+			for field, value := range doc {
+				if config.keep(field) {
+					trimmed[field] = value
+				}
+			}
+			if err := w.Write(trimmed); err != nil {
+				log.Fatalf("Error writing %s: %v", *outputPath, err)
+			}
+			total++
+		}
+	}
+
+	if err := w.End(); err != nil {
+		log.Fatalf("Error writing %s: %v", *outputPath, err)
+	}
+	if err := out.Flush(); err != nil {
+		log.Fatalf("Error writing %s: %v", *outputPath, err)
+	}
+
+	fmt.Printf("Trimmed %d documents. Output written to %s\n", total, *outputPath)
+}
+
+// pagePaths lists the pages written by crawl in offset order
+func pagePaths(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "solr.*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	offset := func(p string) int {
+		o := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(p), "solr."), ".json")
+		i, _ := strconv.Atoi(o)
+		return i
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return offset(paths[i]) < offset(paths[j])
+	})
+
+	return paths, nil
+}
+
+func readDocs(path string) ([]map[string]interface{}, error) {
+	var page struct {
+		Response struct {
+			Docs []map[string]interface{} `json:"docs"`
+		} `json:"response"`
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// numbers are kept as solr wrote them, so sizes aren't 1.234567e+06 and longs don't lose digits
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&page); err != nil {
+		return nil, err
+	}
+
+	return page.Response.Docs, nil
+}
+
+type docWriter interface {
+	Begin(w io.Writer) error
+	Write(doc map[string]interface{}) error
+	End() error
+}
+
+type jsonWriter struct {
+	w       io.Writer
+	array   bool
+	written int
+}
+
+func (j *jsonWriter) Begin(w io.Writer) error {
+	j.w = w
+	if j.array {
+		_, err := io.WriteString(w, "[\n")
+		return err
+	}
+	return nil
+}
+
+func (j *jsonWriter) Write(doc map[string]interface{}) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	if j.array && j.written > 0 {
+		if _, err := io.WriteString(j.w, ",\n"); err != nil {
+			return err
+		}
+	}
+	j.written++
+
+	if _, err := j.w.Write(data); err != nil {
+		return err
+	}
+	if !j.array {
+		_, err = io.WriteString(j.w, "\n")
+	}
+	return err
+}
+
+func (j *jsonWriter) End() error {
+	if j.array {
+		_, err := io.WriteString(j.w, "\n]\n")
+		return err
+	}
+	return nil
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func (c *csvWriter) Begin(w io.Writer) error {
+	c.w = csv.NewWriter(w)
+	return c.w.Write(c.columns)
+}
+
+func (c *csvWriter) Write(doc map[string]interface{}) error {
+	record := make([]string, len(c.columns))
+	for i, column := range c.columns {
+		record[i] = cellValue(doc[column])
+	}
+
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) End() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// cellValue flattens a solr value into a single CSV cell
+// the same way solr's CSV response writer does:
+// multiple values are separated by a comma,
+// commas inside a value are escaped as \, and backslashes as \\
+// which is what 011-i7-export-transform expects
+func cellValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.ReplaceAll(strings.ReplaceAll(v, "\\", "\\\\"), ",", "\\,")
+	case []interface{}:
+		values := []string{}
+		for _, e := range v {
+			values = append(values, cellValue(e))
+		}
+		return strings.Join(values, ",")
+	case json.Number:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lehigh-university-libraries/i7-audit/akubra"
+	"github.com/lehigh-university-libraries/i7-audit/ratelimit"
+)
+
+const (
+	statusFetched = "fetched"
+	statusFailed  = "failed"
+	statusMissing = "missing"
+)
+
+type job struct {
+	Pid  string
+	Dsid string
+}
+
+type result struct {
+	Pid        string
+	Dsid       string
+	Status     string
+	HttpStatus int
+	Path       string
+	Err        error
+}
+
+var (
+	// the same error the akubra stores return, so http and disk are handled alike
+	errMissing = akubra.ErrMissing
+)
+
+func main() {
+	pidsPath := flag.String("pids", "pids.csv", "CSV of nid,pid to harvest")
+	dsids := flag.String("dsids", "MODS", "comma separated list of datastream IDs to harvest, FOXML fetches the whole object")
+	urlTemplate := flag.String("url", "https://{namespace}.lib.lehigh.edu/islandora/object/{pid}/datastream/{dsid}/download", "URL to fetch each datastream from")
+	foxmlTemplate := flag.String("foxml-url", "http://localhost:8080/fedora/objects/{pid}/objectXML", "URL to fetch each PID's FOXML from, authenticated with $FEDORA_USER and $FEDORA_PASSWORD if set")
+	dir := flag.String("dir", "xml", "directory to save datastreams into")
+	manifestPath := flag.String("manifest", "manifest.csv", "CSV recording the outcome for every PID and datastream")
+	workers := flag.Int("workers", 10, "number of concurrent downloads")
+	rate := flag.Float64("rate", 5, "maximum requests per second to a single host")
+	retries := flag.Int("retries", 3, "attempts per PID before giving up")
+	from := flag.String("source", "http", "where to harvest from: http, or disk to read fedora's akubra stores directly")
+	objectStore := flag.String("object-store", "/opt/islandora/fedora-objectStore", "fedora's object store, when harvesting from disk")
+	datastreamStore := flag.String("datastream-store", "/opt/islandora/fedora-datastreamStore", "fedora's datastream store, when harvesting from disk")
+	flag.Parse()
+
+	pids, err := readPids(*pidsPath)
+	if err != nil {
+		log.Fatalf("Unable to read %s: %v", *pidsPath, err)
+	}
+
+	jobs := []job{}
+	for _, pid := range pids {
+		for _, dsid := range strings.Split(*dsids, ",") {
+			dsid = strings.TrimSpace(dsid)
+			if dsid == "" {
+				continue
+			}
+			jobs = append(jobs, job{Pid: pid, Dsid: dsid})
+		}
+	}
+
+	manifestFile, err := os.Create(*manifestPath)
+	if err != nil {
+		log.Fatalf("Error creating manifest: %v", err)
+	}
+	defer manifestFile.Close()
+	manifest := csv.NewWriter(manifestFile)
+	manifest.Write([]string{"pid", "dsid", "status", "http_status", "path", "error"})
+
+	h := harvester{
+		dir: *dir,
+	}
+	switch *from {
+	case "http":
+		h.source = httpSource{
+			client:        &http.Client{Timeout: time.Minute},
+			urlTemplate:   *urlTemplate,
+			foxmlTemplate: *foxmlTemplate,
+			fedoraUser:    os.Getenv("FEDORA_USER"),
+			fedoraPass:    os.Getenv("FEDORA_PASSWORD"),
+			retries:       *retries,
+			limiter:       ratelimit.NewHostLimiter(*rate),
+		}
+	case "disk":
+		h.source = diskSource{
+			repository: akubra.NewRepository(*objectStore, *datastreamStore),
+		}
+	default:
+		log.Fatalf("Unknown source %s", *from)
+	}
+
+	ch := make(chan job)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	wg.Add(*workers)
+	for i := 0; i < *workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := range ch {
+				results <- h.harvest(j)
+			}
+		}()
+	}
+	go func() {
+		for _, j := range jobs {
+			ch <- j
+		}
+		close(ch)
+		wg.Wait()
+		close(results)
+	}()
+
+	counts := map[string]int{}
+	for r := range results {
+		counts[r.Status]++
+		errMsg := ""
+		if r.Err != nil {
+			errMsg = r.Err.Error()
+			log.Printf("%s %s %s: %v", r.Pid, r.Dsid, r.Status, r.Err)
+		}
+		httpStatus := ""
+		if r.HttpStatus != 0 {
+			httpStatus = fmt.Sprint(r.HttpStatus)
+		}
+		manifest.Write([]string{r.Pid, r.Dsid, r.Status, httpStatus, r.Path, errMsg})
+		manifest.Flush()
+	}
+
+	if err := manifest.Error(); err != nil {
+		log.Fatalf("Error writing manifest: %v", err)
+	}
+
+	fmt.Printf("Harvest complete. %d fetched, %d missing, %d failed. Manifest written to %s\n", counts[statusFetched], counts[statusMissing], counts[statusFailed], *manifestPath)
+}
+
+// readPids reads the nid,pid export also used by 021 and 022
+func readPids(f string) ([]string, error) {
+	file, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	pids := []string{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 2 || record[1] == "pid" {
+			continue
+		}
+		pids = append(pids, strings.TrimSpace(record[1]))
+	}
+
+	return pids, nil
+}
+
+// source is somewhere datastreams can be harvested from
+// fetch returns errMissing when the object or datastream doesn't exist
+type source interface {
+	fetch(pid, dsid string) ([]byte, int, error)
+}
+
+type harvester struct {
+	source source
+	dir    string
+}
+
+func (h harvester) harvest(j job) result {
+	r := result{Pid: j.Pid, Dsid: j.Dsid}
+
+	namespace, _, found := strings.Cut(j.Pid, ":")
+	if !found || namespace == "" {
+		r.Status = statusFailed
+		r.Err = fmt.Errorf("invalid PID")
+		return r
+	}
+
+	r.Path = filepath.Join(h.dir, namespace, j.Dsid, j.Pid+".xml")
+	if _, err := os.Stat(r.Path); err == nil {
+		r.Status = statusFetched
+		return r
+	}
+
+	body, httpStatus, err := h.source.fetch(j.Pid, j.Dsid)
+	r.HttpStatus = httpStatus
+	if errors.Is(err, errMissing) {
+		r.Status = statusMissing
+		r.Path = ""
+		return r
+	}
+	// anything that isn't well-formed XML counts as a failure
+	// so i7's HTML error and login pages never end up on disk
+	if err == nil {
+		if xmlErr := wellFormed(body); xmlErr != nil {
+			err = fmt.Errorf("malformed XML: %v", xmlErr)
+		}
+	}
+	if err != nil {
+		r.Status = statusFailed
+		r.Path = ""
+		r.Err = err
+		return r
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.Path), 0755); err != nil {
+		r.Status = statusFailed
+		r.Err = err
+		return r
+	}
+	tmp := filepath.Join(filepath.Dir(r.Path), "."+filepath.Base(r.Path)+".tmp")
+	if err := os.WriteFile(tmp, body, 0644); err != nil {
+		r.Status = statusFailed
+		r.Err = err
+		return r
+	}
+	if err := os.Rename(tmp, r.Path); err != nil {
+		r.Status = statusFailed
+		r.Err = err
+		return r
+	}
+
+	r.Status = statusFetched
+	return r
+}
+
+// httpSource downloads datastreams from the i7 web front end
+type httpSource struct {
+	client        *http.Client
+	urlTemplate   string
+	foxmlTemplate string
+	fedoraUser    string
+	fedoraPass    string
+	retries       int
+	limiter       *ratelimit.HostLimiter
+}
+
+// fetch a datastream, retrying with a backoff
+func (h httpSource) fetch(pid, dsid string) ([]byte, int, error) {
+	namespace, _, _ := strings.Cut(pid, ":")
+
+	// FOXML isn't a datastream, it has to come from fedora itself
+	template := h.urlTemplate
+	auth := false
+	if dsid == "FOXML" {
+		template = h.foxmlTemplate
+		auth = h.fedoraUser != ""
+	}
+	u := strings.NewReplacer(
+		"{namespace}", namespace,
+		"{pid}", url.PathEscape(pid),
+		"{dsid}", url.PathEscape(dsid),
+	).Replace(template)
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	backoff := time.Second
+	httpStatus := 0
+	for attempt := 1; attempt <= h.retries; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		h.limiter.Wait(parsed.Host)
+
+		var body []byte
+		body, httpStatus, err = h.get(u, auth)
+		if err == nil || errors.Is(err, errMissing) {
+			return body, httpStatus, err
+		}
+	}
+
+	return nil, httpStatus, err
+}
+
+func (h httpSource) get(u string, auth bool) ([]byte, int, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if auth {
+		req.SetBasicAuth(h.fedoraUser, h.fedoraPass)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, resp.StatusCode, errMissing
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+
+	if strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return nil, resp.StatusCode, fmt.Errorf("received HTML instead of XML")
+	}
+	// retry truncated responses
+	if err := wellFormed(body); err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("malformed XML: %v", err)
+	}
+
+	return body, resp.StatusCode, nil
+}
+
+// diskSource reads datastreams straight out of fedora's akubra stores
+type diskSource struct {
+	repository akubra.Repository
+}
+
+func (d diskSource) fetch(pid, dsid string) ([]byte, int, error) {
+	body, err := d.repository.Content(pid, dsid)
+	return body, 0, err
+}
+
+func wellFormed(body []byte) error {
+	d := xml.NewDecoder(bytes.NewReader(body))
+	root := false
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := token.(xml.StartElement); ok {
+			root = true
+		}
+	}
+	if !root {
+		return fmt.Errorf("no root element")
+	}
+
+	return nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	agentsFilePath           = "agents.csv"
+	unresolvedAgentsFilePath = "agents_unresolved.csv"
+)
+
+var (
+	agentTypes = map[string]string{}
+	// never prompt, guess instead
+	batchAgents bool
+	// what batch mode does with agents the heuristics can't type
+	// "fail" or the type to default to
+	unknownAgentType = "fail"
+	// every agent batch mode had to type: name, type, reason
+	unresolvedAgents = [][]string{}
+
+	// the X family heading form, with a qualifier i.e. Adams family (Adams, John), but not Family Service Association
+	familyPattern    = regexp.MustCompile(`(?i)^[\p{L}'’\-]+( [\p{L}'’\-]+)? family\.?(\s*[:(,].*)?$`)
+	corporatePattern = regexp.MustCompile(`(?i)\b(university|college|school|academy|institute|institution|company|co|inc|incorporated|corporation|corp|ltd|society|association|assn|club|library|libraries|museum|archives|department|dept|church|hospital|foundation|commission|committee|council|board|bureau|office|agency|bank|railroad|railway|press|publishers|publishing|studios?|bros|brothers|sons|laboratory|laboratories|center|centre|government|congress|united states)\b|&`)
+	// Last, First with optional life dates
+	invertedNamePattern = regexp.MustCompile(`^[\p{L}'’.\- ]+, [\p{L}'’.\- ]+(, [0-9?\- .]+)?$`)
+	lifeDatesPattern    = regexp.MustCompile(`, (b\. |d\. )?[0-9]{4}\??-([0-9]{4}\??)?$`)
+)
+
+// agentDecision is a row in agents.csv
+// a later row for the same agent overrides an earlier one
+type agentDecision struct {
+	Agent string
+	Type  string
+	// who or what chose the type, i.e. prompt:$USER or heuristic:$reason
+	// empty for rows written before this was recorded
+	DecidedBy string
+	DecidedAt string
+}
+
+func init() {
+	// prepopulate the agent types based on a CSV
+	// with the columns: string (i.e. agent), its linked agent type
+	// i.e. person/corporate_body/family, and optionally who decided that and when
+	decisions, err := readAgentDecisions(agentsFilePath)
+	if err != nil {
+		fmt.Println("Error opening CSV file:", err)
+		return
+	}
+
+	for _, d := range decisions {
+		agentTypes[d.Agent] = d.Type
+	}
+}
+
+func readAgentDecisions(f string) ([]agentDecision, error) {
+	file, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	decisions := []agentDecision{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return decisions, err
+		}
+		if len(record) < 2 {
+			continue
+		}
+		d := agentDecision{Agent: record[0], Type: record[1]}
+		if len(record) > 2 {
+			d.DecidedBy = record[2]
+		}
+		if len(record) > 3 {
+			d.DecidedAt = record[3]
+		}
+		decisions = append(decisions, d)
+	}
+
+	return decisions, nil
+}
+
+// writeAgentDecisions replaces agents.csv
+func writeAgentDecisions(f string, decisions []agentDecision) error {
+	tmp := filepath.Join(filepath.Dir(f), "."+filepath.Base(f)+".tmp")
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(file)
+	for _, d := range decisions {
+		w.Write(d.record())
+	}
+	w.Flush()
+	file.Close()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, f)
+}
+
+func (d agentDecision) record() []string {
+	if d.DecidedBy == "" && d.DecidedAt == "" {
+		return []string{d.Agent, d.Type}
+	}
+
+	return []string{d.Agent, d.Type, d.DecidedBy, d.DecidedAt}
+}
+
+func cacheAgentType(v string) {
+	_, exists := agentTypes[v]
+	if exists {
+		return
+	}
+
+	if batchAgents {
+		guessAgentType(v)
+		return
+	}
+
+	for {
+		fmt.Printf("Enter your choice for %s (corporate_body [c], family [f], or person [p]):\n", v)
+		var input string
+		_, err := fmt.Scanln(&input)
+		if err != nil {
+			fmt.Println("Error reading input:", err)
+			os.Exit(1)
+		}
+
+		agentType, ok := agentTypeChoice(strings.ToLower(input))
+		if ok {
+			agentTypes[v] = agentType
+			break
+		}
+		fmt.Printf("%q isn't one of the choices\n", input)
+	}
+
+	// save the answer right away so a crash doesn't lose it
+	if err := appendAgent(v, agentTypes[v], "prompt:"+currentUser()); err != nil {
+		fmt.Printf("Error saving %s to %s: %v\n", v, agentsFilePath, err)
+	}
+}
+
+// agentTypeChoice is false for anything that isn't one of the choices, so it can be asked again
+func agentTypeChoice(input string) (string, bool) {
+	switch input {
+	case "c":
+		return "corporate_body", true
+	case "f":
+		return "family", true
+	case "p":
+		return "person", true
+	}
+
+	return "", false
+}
+
+func currentUser() string {
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+
+	return "unknown"
+}
+
+// guessAgentType types an agent without asking anyone
+// and writes the guess back to agents.csv so the next run doesn't have to guess again
+func guessAgentType(v string) {
+	agentType, reason := agentTypeHeuristic(v)
+	decidedBy := "heuristic:" + reason
+	if agentType == "" && unknownAgentType != "fail" {
+		agentType, reason = unknownAgentType, "default"
+		decidedBy = "default"
+	}
+
+	agentTypes[v] = agentType
+	unresolvedAgents = append(unresolvedAgents, []string{v, agentType, reason})
+	if agentType == "" {
+		return
+	}
+
+	if err := appendAgent(v, agentType, decidedBy); err != nil {
+		fmt.Printf("Error saving %s to %s: %v\n", v, agentsFilePath, err)
+	}
+}
+
+// agentTypeHeuristic returns an empty type when it can't tell
+func agentTypeHeuristic(v string) (string, string) {
+	if familyPattern.MatchString(v) {
+		return "family", "family name"
+	}
+	// a one word surname is left out, so Church, Frederic Edwin is still a person
+	// but Lehigh University, Library isn't
+	keywords := v
+	if surname, rest, found := strings.Cut(v, ", "); found && !strings.Contains(surname, " ") {
+		keywords = rest
+	}
+	if corporatePattern.MatchString(keywords) {
+		return "corporate_body", "organization keyword"
+	}
+	if lifeDatesPattern.MatchString(v) {
+		return "person", "life dates"
+	}
+	if invertedNamePattern.MatchString(v) {
+		return "person", "Last, First"
+	}
+
+	return "", "no match"
+}
+
+// appendAgent adds a row to agents.csv
+func appendAgent(agent, agentType, decidedBy string) error {
+	file, err := os.OpenFile(agentsFilePath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// don't glue the new row onto a last line with no newline
+	end, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if end > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, end-1); err != nil {
+			return err
+		}
+		if last[0] != '\n' {
+			if _, err := file.Write([]byte("\n")); err != nil {
+				return err
+			}
+		}
+	}
+
+	w := csv.NewWriter(file)
+	d := agentDecision{
+		Agent:     agent,
+		Type:      agentType,
+		DecidedBy: decidedBy,
+		DecidedAt: time.Now().Format(time.RFC3339),
+	}
+	w.Write(d.record())
+	w.Flush()
+
+	return w.Error()
+}
+
+// writeUnresolvedAgents records every agent batch mode typed, and how
+func writeUnresolvedAgents(f string) error {
+	return writeCsv(f, []string{"agent", "type", "reason"}, unresolvedAgents)
+}
+
+// untypedAgents are the agents batch mode couldn't type
+func untypedAgents() int {
+	untyped := 0
+	for _, agent := range unresolvedAgents {
+		if agent[1] == "" {
+			untyped++
+		}
+	}
+
+	return untyped
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// agentsCommand inspects and corrects the decisions saved in agents.csv
+func agentsCommand(args []string) {
+	if len(args) < 1 {
+		agentsUsage()
+	}
+
+	switch args[0] {
+	case "list":
+		listAgents(args[1:])
+	case "review":
+		reviewAgents(args[1:])
+	case "undo":
+		undoAgents(args[1:])
+	default:
+		agentsUsage()
+	}
+}
+
+func agentsUsage() {
+	fmt.Println("Usage: go run *.go agents list|review|undo [flags]")
+	os.Exit(1)
+}
+
+// currentDecisions is the decision in effect for every agent, in the order they were first decided
+func currentDecisions(decisions []agentDecision) []agentDecision {
+	latest := map[string]int{}
+	order := []string{}
+	for i, d := range decisions {
+		if _, found := latest[d.Agent]; !found {
+			order = append(order, d.Agent)
+		}
+		latest[d.Agent] = i
+	}
+
+	current := []agentDecision{}
+	for _, agent := range order {
+		current = append(current, decisions[latest[agent]])
+	}
+
+	return current
+}
+
+func listAgents(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	by := fs.String("by", "", "only agents decided by this, i.e. prompt, heuristic or default")
+	agentType := fs.String("type", "", "only agents of this type")
+	all := fs.Bool("all", false, "include decisions that were later overridden")
+	fs.Parse(args)
+
+	decisions, err := readAgentDecisions(agentsFilePath)
+	if err != nil {
+		log.Fatalf("Unable to read %s: %v", agentsFilePath, err)
+	}
+	if !*all {
+		decisions = currentDecisions(decisions)
+	}
+
+	for _, d := range decisions {
+		if !strings.HasPrefix(d.DecidedBy, *by) || (*agentType != "" && d.Type != *agentType) {
+			continue
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\n", d.Agent, d.Type, d.DecidedBy, d.DecidedAt)
+	}
+}
+
+// reviewAgents walks through earlier decisions so a human can confirm or correct them
+// corrections are appended to agents.csv like any other decision
+func reviewAgents(args []string) {
+	fs := flag.NewFlagSet("review", flag.ExitOnError)
+	by := fs.String("by", "heuristic", "review agents decided by this, i.e. prompt, heuristic or default")
+	fs.Parse(args)
+
+	decisions, err := readAgentDecisions(agentsFilePath)
+	if err != nil {
+		log.Fatalf("Unable to read %s: %v", agentsFilePath, err)
+	}
+
+	input := bufio.NewScanner(os.Stdin)
+	reviewed, changed := 0, 0
+	for _, d := range currentDecisions(decisions) {
+		if !strings.HasPrefix(d.DecidedBy, *by) {
+			continue
+		}
+
+		choice, agentType := "", ""
+		for {
+			fmt.Printf("%s is %s (%s). Keep [enter], or change to corporate_body [c], family [f], person [p], or quit [q]:\n", d.Agent, d.Type, d.DecidedBy)
+			if !input.Scan() {
+				choice = "q"
+				break
+			}
+			choice = strings.ToLower(strings.TrimSpace(input.Text()))
+			var ok bool
+			if agentType, ok = agentTypeChoice(choice); ok || choice == "" || choice == "q" {
+				break
+			}
+			fmt.Printf("%q isn't one of the choices\n", choice)
+		}
+		if choice == "q" {
+			break
+		}
+		reviewed++
+		if choice == "" || agentType == d.Type {
+			continue
+		}
+		if err := appendAgent(d.Agent, agentType, "review:"+currentUser()); err != nil {
+			log.Fatalf("Error saving %s to %s: %v", d.Agent, agentsFilePath, err)
+		}
+		changed++
+	}
+
+	fmt.Printf("Reviewed %d agents, changed %d\n", reviewed, changed)
+}
+
+// undoAgents removes the latest decisions from agents.csv
+// so the agent falls back to its previous type, or is asked about again
+func undoAgents(args []string) {
+	fs := flag.NewFlagSet("undo", flag.ExitOnError)
+	n := fs.Int("n", 1, "number of decisions to undo, when no agents are named")
+	fs.Usage = func() {
+		fmt.Println("Usage: go run *.go agents undo [-n N] [agent ...]")
+		fmt.Println("Undo the last N decisions, or the latest decision for each agent named")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	decisions, err := readAgentDecisions(agentsFilePath)
+	if err != nil {
+		log.Fatalf("Unable to read %s: %v", agentsFilePath, err)
+	}
+
+	undo := map[int]bool{}
+	if fs.NArg() == 0 {
+		for i := len(decisions) - 1; i >= 0 && len(undo) < *n; i-- {
+			undo[i] = true
+		}
+	}
+	for _, agent := range fs.Args() {
+		found := false
+		for i := len(decisions) - 1; i >= 0; i-- {
+			if decisions[i].Agent == agent {
+				undo[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			fmt.Printf("%s isn't in %s\n", agent, agentsFilePath)
+		}
+	}
+
+	kept := []agentDecision{}
+	for i, d := range decisions {
+		if undo[i] {
+			fmt.Printf("Undid %s\t%s\t%s\t%s\n", d.Agent, d.Type, d.DecidedBy, d.DecidedAt)
+			continue
+		}
+		kept = append(kept, d)
+	}
+
+	if err := writeAgentDecisions(agentsFilePath, kept); err != nil {
+		log.Fatalf("Unable to write %s: %v", agentsFilePath, err)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	authorityReviewFilePath = "authority_review.csv"
+	agentsAuthorityFilePath = "agents_authority.csv"
+)
+
+// predicates holding a name's authorized heading, and its other forms
+var (
+	preferredLabels = []string{
+		"http://www.w3.org/2004/02/skos/core#prefLabel",
+		"http://www.loc.gov/mads/rdf/v1#authoritativeLabel",
+	}
+	variantLabels = []string{
+		"http://www.w3.org/2004/02/skos/core#altLabel",
+		"http://www.loc.gov/mads/rdf/v1#variantLabel",
+		"http://www.w3.org/2000/01/rdf-schema#label",
+		"http://schema.org/name",
+		"http://schema.org/alternateName",
+	}
+	// predicates linking a record to a blank node holding one of its other forms, i.e. LC's madsrdf:Variant
+	variantNodes = []string{
+		"http://www.loc.gov/mads/rdf/v1#hasVariant",
+		"http://www.loc.gov/mads/rdf/v1#hasEarlierEstablishedForm",
+	}
+
+	tripleLiteral   = regexp.MustCompile(`^(<[^>]+>|_:\S+)\s+<([^>]+)>\s+("(?:[^"\\]|\\.)*")(?:@[A-Za-z0-9-]+|\^\^<[^>]+>)?\s*\.\s*$`)
+	tripleBlankNode = regexp.MustCompile(`^<([^>]+)>\s+<([^>]+)>\s+(_:\S+)\s*\.\s*$`)
+	// trailing life dates i.e. "Packer, Asa, 1805-1879"
+	headingDates = regexp.MustCompile(`,\s*((b\.|d\.|ca\.)\s*[0-9]{4}|[0-9]{4}\??-([0-9]{4}\??)?)\.?$`)
+)
+
+// authorityIndex matches agent names to authority records from an LCNAF or VIAF dump
+type authorityIndex struct {
+	// uri => authorized heading
+	headings map[string]string
+	// normalized heading or variant => uris
+	keys map[string][]string
+}
+
+type authorityMatch struct {
+	Agent   string
+	Type    string
+	Heading string
+	URI     string
+}
+
+type authorityReview struct {
+	Agent      string
+	Type       string
+	Status     string
+	Candidates []string
+}
+
+var (
+	authority *authorityIndex
+	// agent and type => outcome, so each agent is only reported once
+	authorityMatches = map[string]authorityMatch{}
+	authorityReviews = map[string]authorityReview{}
+)
+
+// loadAuthority reads an N-Triples dump (.nt) or a CSV with uri and heading columns
+// in a CSV, a uri's first heading is its authorized heading and any others are variants
+func loadAuthority(f string) (*authorityIndex, error) {
+	file, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	a := &authorityIndex{
+		headings: map[string]string{},
+		keys:     map[string][]string{},
+	}
+	if strings.HasSuffix(f, ".nt") {
+		err = a.readNTriples(file)
+	} else {
+		err = a.readCsv(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(a.headings) == 0 {
+		return nil, fmt.Errorf("no headings found")
+	}
+
+	return a, nil
+}
+
+func (a *authorityIndex) readCsv(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	uriColumn, headingColumn := -1, -1
+	for i, column := range header {
+		switch strings.ToLower(strings.TrimSpace(column)) {
+		case "uri":
+			uriColumn = i
+		case "heading":
+			headingColumn = i
+		}
+	}
+	if uriColumn == -1 || headingColumn == -1 {
+		return fmt.Errorf("CSV needs uri and heading columns")
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) <= uriColumn || len(record) <= headingColumn {
+			continue
+		}
+		uri, heading := strings.TrimSpace(record[uriColumn]), strings.TrimSpace(record[headingColumn])
+		if uri == "" || heading == "" {
+			continue
+		}
+		if _, found := a.headings[uri]; !found {
+			a.headings[uri] = heading
+		}
+		a.add(heading, uri)
+	}
+
+	return nil
+}
+
+func (a *authorityIndex) readNTriples(r io.Reader) error {
+	variants := map[string][]string{}
+	// blank node => the records it's a variant of, and its labels
+	nodeRecords, nodeLabels := map[string][]string{}, map[string][]string{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if match := tripleBlankNode.FindStringSubmatch(scanner.Text()); match != nil {
+			if strInSlice(match[2], variantNodes) {
+				nodeRecords[match[3]] = append(nodeRecords[match[3]], match[1])
+			}
+			continue
+		}
+		match := tripleLiteral.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		subject, predicate := match[1], match[2]
+		label, err := strconv.Unquote(match[3])
+		if err != nil {
+			continue
+		}
+
+		// blank nodes aren't records, their labels are only used if a record links to them
+		if strings.HasPrefix(subject, "_:") {
+			if strInSlice(predicate, preferredLabels) || strInSlice(predicate, variantLabels) {
+				nodeLabels[subject] = append(nodeLabels[subject], label)
+			}
+			continue
+		}
+		uri := strings.Trim(subject, "<>")
+
+		if strInSlice(predicate, preferredLabels) {
+			if _, found := a.headings[uri]; !found {
+				a.headings[uri] = label
+			}
+			a.add(label, uri)
+		} else if strInSlice(predicate, variantLabels) {
+			variants[uri] = append(variants[uri], label)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for node, uris := range nodeRecords {
+		if len(nodeLabels[node]) == 0 {
+			continue
+		}
+		for _, uri := range uris {
+			variants[uri] = append(variants[uri], nodeLabels[node]...)
+		}
+	}
+	for uri, labels := range variants {
+		// without a preferred label the first variant will have to do
+		if _, found := a.headings[uri]; !found {
+			a.headings[uri] = labels[0]
+		}
+		for _, label := range labels {
+			a.add(label, uri)
+		}
+	}
+
+	return nil
+}
+
+// add indexes a heading with and without its life dates
+func (a *authorityIndex) add(heading, uri string) {
+	for _, key := range []string{normalizeHeading(heading), normalizeHeading(headingDates.ReplaceAllString(heading, ""))} {
+		if key == "" || strInSlice(uri, a.keys[key]) {
+			continue
+		}
+		a.keys[key] = append(a.keys[key], uri)
+	}
+}
+
+// lookup returns every uri the name could refer to
+// preferring an exact match over one that ignores life dates
+func (a *authorityIndex) lookup(name string) []string {
+	if uris := a.keys[normalizeHeading(name)]; len(uris) > 0 {
+		return uris
+	}
+
+	return a.keys[normalizeHeading(headingDates.ReplaceAllString(name, ""))]
+}
+
+// normalizeHeading ignores case, punctuation and spacing
+func normalizeHeading(s string) string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	return strings.Join(words, " ")
+}
+
+// reconcileAgent returns the authorized heading for an agent
+// or the name as is when there's no single match
+func reconcileAgent(name, agentType string) string {
+	if authority == nil {
+		return name
+	}
+	// the same name can be a person in one field and a corporate body in another
+	key := name + "\x00" + agentType
+	if m, found := authorityMatches[key]; found {
+		return m.Heading
+	}
+	if _, found := authorityReviews[key]; found {
+		return name
+	}
+
+	uris := authority.lookup(name)
+	switch len(uris) {
+	case 1:
+		m := authorityMatch{
+			Agent:   name,
+			Type:    agentType,
+			Heading: authority.headings[uris[0]],
+			URI:     uris[0],
+		}
+		authorityMatches[key] = m
+		return m.Heading
+	case 0:
+		authorityReviews[key] = authorityReview{Agent: name, Type: agentType, Status: "unmatched"}
+	default:
+		candidates := []string{}
+		for _, uri := range uris {
+			candidates = append(candidates, fmt.Sprintf("%s %s", uri, authority.headings[uri]))
+		}
+		sort.Strings(candidates)
+		authorityReviews[key] = authorityReview{Agent: name, Type: agentType, Status: "ambiguous", Candidates: candidates}
+	}
+
+	return name
+}
+
+// writeAuthorityReports writes the matched headings, to create taxonomy terms with their authority links,
+// and the names that need a human to look at them
+func writeAuthorityReports() error {
+	matches := [][]string{}
+	seen := map[string]bool{}
+	for _, m := range authorityMatches {
+		// several spellings can reconcile to the same authority, but two authorities can share a heading
+		key := m.URI + "\x00" + m.Type
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		matches = append(matches, []string{m.Heading, m.Type, m.URI})
+	}
+	sortRows(matches)
+	if err := writeCsv(agentsAuthorityFilePath, []string{"heading", "type", "uri"}, matches); err != nil {
+		return err
+	}
+
+	reviews := [][]string{}
+	for _, r := range authorityReviews {
+		reviews = append(reviews, []string{r.Agent, r.Type, r.Status, strings.Join(r.Candidates, "|")})
+	}
+	sortRows(reviews)
+
+	return writeCsv(authorityReviewFilePath, []string{"agent", "type", "status", "candidates"}, reviews)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"sort"
+	"strings"
+)
+
+const changesFilePath = "changes.csv"
+
+type transformRun struct {
+	header []string
+	// column name => index, the first one when a name is repeated
+	columns map[string]int
+	// first column, the PID => row
+	rows map[string][]string
+}
+
+func readTransformRun(f string) (transformRun, error) {
+	run := transformRun{columns: map[string]int{}, rows: map[string][]string{}}
+
+	file, err := os.Open(f)
+	if err != nil {
+		return run, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return run, err
+	}
+	if len(records) == 0 {
+		return run, nil
+	}
+	run.header = records[0]
+	for i, name := range run.header {
+		if _, found := run.columns[name]; !found {
+			run.columns[name] = i
+		}
+	}
+	for _, record := range records[1:] {
+		run.rows[record[0]] = record
+	}
+
+	return run, nil
+}
+
+func (run transformRun) value(record []string, column string) (string, bool) {
+	i, found := run.columns[column]
+	if !found || i >= len(record) {
+		return "", false
+	}
+
+	return record[i], true
+}
+
+// comparableValue ignores the order of | separated values
+// so runs from before the output order was stable compare cleanly
+func comparableValue(cell string) string {
+	values := strings.Split(cell, "|")
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	sort.Strings(values)
+
+	return strings.Join(values, "|")
+}
+
+// comparePrevious writes the real differences between an earlier output.csv and this one
+func comparePrevious(previousPath, currentPath string) (int, error) {
+	previous, err := readTransformRun(previousPath)
+	if err != nil {
+		return 0, err
+	}
+	current, err := readTransformRun(currentPath)
+	if err != nil {
+		return 0, err
+	}
+
+	columns := append([]string{}, current.header...)
+	for i, column := range previous.header {
+		if _, found := current.columns[column]; !found && previous.columns[column] == i {
+			columns = append(columns, column)
+		}
+	}
+
+	pids := []string{}
+	for pid := range current.rows {
+		pids = append(pids, pid)
+	}
+	for pid := range previous.rows {
+		if _, found := current.rows[pid]; !found {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Strings(pids)
+
+	changes := [][]string{}
+	for _, pid := range pids {
+		before, inPrevious := previous.rows[pid]
+		after, inCurrent := current.rows[pid]
+		if !inPrevious {
+			changes = append(changes, []string{pid, "", "added", "", ""})
+			continue
+		}
+		if !inCurrent {
+			changes = append(changes, []string{pid, "", "removed", "", ""})
+			continue
+		}
+
+		for _, column := range columns {
+			was, hadColumn := previous.value(before, column)
+			now, hasColumn := current.value(after, column)
+			if hadColumn && hasColumn && comparableValue(was) == comparableValue(now) {
+				continue
+			}
+			// a column only one run has is only a change where it holds something
+			if was == "" && now == "" {
+				continue
+			}
+			changes = append(changes, []string{pid, column, "changed", was, now})
+		}
+	}
+
+	return len(changes), writeCsv(changesFilePath, []string{"pid", "field", "change", "previous", "current"}, changes)
+}
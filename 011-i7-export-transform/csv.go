@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"sort"
+)
+
+// writeCsv writes a report, replacing any earlier one
+func writeCsv(f string, header []string, rows [][]string) error {
+	file, err := os.Create(f)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write(header)
+	w.WriteAll(rows)
+
+	return w.Error()
+}
+
+// sortRows sorts a report by its first column, then its second and so on
+// so the same input always gives the same file
+func sortRows(rows [][]string) {
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+}
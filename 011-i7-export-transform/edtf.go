@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const edtfReportColumn = "edtf_report"
+
+var (
+	// a level 1 EDTF date, including unspecified digits, seasons and qualifiers
+	edtfDate = regexp.MustCompile(`^(Y-?[0-9]{5,}|-?[0-9]{2}([0-9]{2}|[0-9]X|XX))(-([0-9]{2}|XX)(-([0-9]{2}|XX))?)?[?~%]?$`)
+	// what solr does to dates i.e. 1923-01-01T00:00:00Z, or a database does i.e. 1923-01-01 00:00:00
+	isoTimestamp = regexp.MustCompile(`^([0-9]{4}-[0-9]{2}-[0-9]{2})[T ][0-9]{2}:[0-9]{2}(:[0-9]{2}(\.[0-9]+)?)?(Z|[+-][0-9]{2}:?[0-9]{2})?$`)
+	// a copyright date i.e. c1920, which is when it was published rather than a guess
+	copyright = regexp.MustCompile(`(?i)^(c|©|copyright)\s*([0-9]{4})$`)
+	// Sept. isn't an abbreviation time.Parse knows
+	september   = regexp.MustCompile(`(?i)\bsept\b`)
+	decade      = regexp.MustCompile(`^([0-9]{3})0'?s$`)
+	century     = regexp.MustCompile(`(?i)^([0-9]{1,2})(st|nd|rd|th) century$`)
+	season      = regexp.MustCompile(`(?i)^(spring|summer|autumn|fall|winter),? ([0-9]{4})$`)
+	approximate = regexp.MustCompile(`(?i)^(ca\.?|circa|c\.|approximately|approx\.?|about|around)\s*`)
+	uncertain   = regexp.MustCompile(`(?i)^(probably|possibly)\s+`)
+	between     = regexp.MustCompile(`(?i)^between\s+(.+)\s+and\s+(.+)$`)
+	dateRange   = regexp.MustCompile(`(?i)^(.+?)\s*(?:-|–|—|\bto\b)\s*(.+)$`)
+	// a range with only the last two digits of the end year i.e. 1920-21, which EDTF would read as a season
+	shortRange = regexp.MustCompile(`^([0-9]{2})([0-9]{2})\s*[-–—]\s*([0-9]{2})$`)
+	// a range with no end i.e. 1850-
+	openRange = regexp.MustCompile(`(?i)^(.+?)\s*(?:-|–|—|\bto\b)$`)
+	// MARC's blank digits i.e. 19-- or 192-?
+	marcBlank = regexp.MustCompile(`^([0-9]{2})([0-9]|-)-(\?)?$`)
+
+	// dates written out in words or the US style
+	dateLayouts = []struct {
+		layout string
+		edtf   string
+	}{
+		{"January 2, 2006", "2006-01-02"},
+		{"January 2 2006", "2006-01-02"},
+		{"Jan 2, 2006", "2006-01-02"},
+		{"Jan. 2, 2006", "2006-01-02"},
+		{"2 January 2006", "2006-01-02"},
+		{"2 Jan 2006", "2006-01-02"},
+		{"1/2/2006", "2006-01-02"},
+		{"January 2006", "2006-01"},
+		{"Jan 2006", "2006-01"},
+		{"Jan. 2006", "2006-01"},
+		{"January, 2006", "2006-01"},
+		{"2006 January", "2006-01"},
+		{"2006 January 2", "2006-01-02"},
+		{"2006-1-2", "2006-01-02"},
+	}
+)
+
+// isEDTF checks a date or interval against EDTF level 1
+func isEDTF(v string) bool {
+	start, end, interval := strings.Cut(v, "/")
+	if !interval {
+		return isEDTFDate(v)
+	}
+	if start == "" && end == "" {
+		return false
+	}
+	for _, d := range []string{start, end} {
+		if d != "" && d != ".." && !isEDTFDate(d) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isEDTFDate(v string) bool {
+	match := edtfDate.FindStringSubmatch(v)
+	if match == nil {
+		return false
+	}
+
+	month, day := match[4], match[6]
+	if month == "" || month == "XX" {
+		return day == "" || day == "XX"
+	}
+	m, _ := strconv.Atoi(month)
+	// 21-24 are seasons, which don't have days
+	if m >= 21 && m <= 24 {
+		return day == ""
+	}
+	if m < 1 || m > 12 {
+		return false
+	}
+	if day == "" || day == "XX" {
+		return true
+	}
+	d, _ := strconv.Atoi(day)
+	if d < 1 || d > 31 {
+		return false
+	}
+	// a year with unspecified digits could be a leap year
+	year, err := strconv.Atoi(match[1])
+	if err != nil {
+		year = 2000
+	}
+
+	return time.Date(year, time.Month(m), d, 0, 0, 0, 0, time.UTC).Day() == d
+}
+
+// normalizeEDTF converts a date as catalogued in i7 to EDTF level 1
+func normalizeEDTF(raw string) (string, error) {
+	v := strings.TrimSpace(raw)
+	v = strings.TrimSuffix(strings.TrimPrefix(v, "["), "]")
+	// a full stop after a date, but not the .. of an open interval
+	if !strings.HasSuffix(v, "..") {
+		v = strings.TrimSpace(strings.TrimSuffix(v, "."))
+	}
+	// checked before isEDTF, which would take 1920-21 as spring 1920
+	if match := shortRange.FindStringSubmatch(v); match != nil {
+		start, _ := strconv.Atoi(match[2])
+		end, _ := strconv.Atoi(match[3])
+		switch {
+		case end > start && end > 12:
+			return match[1] + match[2] + "/" + match[1] + match[3], nil
+		case end >= 21 && end <= 24:
+			return "", fmt.Errorf("can't convert %q, it could be a season or a range", raw)
+		}
+	}
+	if v == "" || isEDTF(v) {
+		return v, nil
+	}
+
+	if match := isoTimestamp.FindStringSubmatch(v); match != nil {
+		return match[1], nil
+	}
+	if match := copyright.FindStringSubmatch(v); match != nil {
+		return match[2], nil
+	}
+	for _, l := range dateLayouts {
+		if t, err := time.Parse(l.layout, september.ReplaceAllString(v, "Sep")); err == nil {
+			return t.Format(l.edtf), nil
+		}
+	}
+	if match := marcBlank.FindStringSubmatch(v); match != nil {
+		return match[1] + strings.ReplaceAll(match[2], "-", "X") + "X" + match[3], nil
+	}
+	if match := decade.FindStringSubmatch(v); match != nil {
+		return match[1] + "X", nil
+	}
+	if match := season.FindStringSubmatch(v); match != nil {
+		seasons := map[string]string{"spring": "21", "summer": "22", "autumn": "23", "fall": "23", "winter": "24"}
+		return match[2] + "-" + seasons[strings.ToLower(match[1])], nil
+	}
+	if match := century.FindStringSubmatch(v); match != nil {
+		n, _ := strconv.Atoi(match[1])
+		if n > 0 {
+			return fmt.Sprintf("%02dXX", n-1), nil
+		}
+	}
+
+	// approximate (~), uncertain (?) or both (%)
+	qualifier := ""
+	rest := v
+	if match := approximate.FindString(rest); match != "" {
+		qualifier = "~"
+		rest = strings.TrimPrefix(rest, match)
+	}
+	if match := uncertain.FindString(rest); match != "" {
+		rest = strings.TrimPrefix(rest, match)
+		qualifier += "?"
+	} else if strings.HasSuffix(rest, "?") && !strings.Contains(rest, "/") && !dateRange.MatchString(strings.TrimSuffix(rest, "?")) {
+		rest = strings.TrimSpace(strings.TrimSuffix(rest, "?"))
+		qualifier += "?"
+	}
+	if qualifier != "" {
+		d, err := normalizeEDTF(rest)
+		if err != nil || d == "" {
+			return "", fmt.Errorf("can't convert %q", raw)
+		}
+		// a qualifier in front of a range applies to both ends, i.e. ca. 1901-1905 is 1901~/1905~
+		ends := strings.Split(d, "/")
+		for i, end := range ends {
+			if end != "" && end != ".." {
+				ends[i] = qualify(end, qualifier)
+			}
+		}
+		return strings.Join(ends, "/"), nil
+	}
+
+	if match := openRange.FindStringSubmatch(v); match != nil {
+		d, err := normalizeEDTF(match[1])
+		if err != nil || d == "" || strings.Contains(d, "/") {
+			return "", fmt.Errorf("can't convert %q", raw)
+		}
+		return d + "/..", nil
+	}
+	if match := between.FindStringSubmatch(v); match != nil {
+		return normalizeInterval(raw, match[1], match[2])
+	}
+	if match := dateRange.FindStringSubmatch(v); match != nil {
+		return normalizeInterval(raw, match[1], match[2])
+	}
+
+	return "", fmt.Errorf("can't convert %q", raw)
+}
+
+// qualify adds ~ or ? to a date, combining them into % when it has both
+func qualify(d, qualifier string) string {
+	qualifier += d[len(strings.TrimRight(d, "?~%")):]
+	d = strings.TrimRight(d, "?~%")
+	approx := strings.ContainsAny(qualifier, "~%")
+	unsure := strings.ContainsAny(qualifier, "?%")
+	switch {
+	case approx && unsure:
+		return d + "%"
+	case approx:
+		return d + "~"
+	case unsure:
+		return d + "?"
+	}
+
+	return d
+}
+
+func normalizeInterval(raw, start, end string) (string, error) {
+	s, err := normalizeEDTF(start)
+	if err != nil || s == "" || strings.Contains(s, "/") {
+		return "", fmt.Errorf("can't convert %q", raw)
+	}
+	e, err := normalizeEDTF(end)
+	if err != nil || e == "" || strings.Contains(e, "/") {
+		return "", fmt.Errorf("can't convert %q", raw)
+	}
+
+	return s + "/" + e, nil
+}
+
+// normalizeDates converts every value of a date field into a | separated cell
+// values that can't be converted are left out and returned as problems
+func normalizeDates(values []string) (string, []string) {
+	dates := []string{}
+	problems := []string{}
+	for _, v := range values {
+		d, err := normalizeEDTF(v)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if d == "" || strInSlice(d, dates) {
+			continue
+		}
+		dates = append(dates, d)
+	}
+
+	return strings.Join(dates, "|"), problems
+}
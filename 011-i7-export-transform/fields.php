@@ -0,0 +1,21 @@
+<?php
+
+// Export a bundle's field definitions for validating output.csv.
+// drush scr fields.php islandora_object > fields.csv
+
+$bundle = $extra[0] ?? 'islandora_object';
+$definitions = \Drupal::service('entity_field.manager')->getFieldDefinitions('node', $bundle);
+
+$out = fopen('php://stdout', 'w');
+fputcsv($out, ['field_name', 'type', 'cardinality', 'target_type', 'target_bundles']);
+foreach ($definitions as $name => $definition) {
+  $settings = $definition->getSettings();
+  $bundles = $settings['handler_settings']['target_bundles'] ?? [];
+  fputcsv($out, [
+    $name,
+    $definition->getType(),
+    $definition->getFieldStorageDefinition()->getCardinality(),
+    $settings['target_type'] ?? '',
+    implode('|', array_keys($bundles ?: [])),
+  ]);
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lehigh-university-libraries/i7-audit/akubra"
+	"github.com/lehigh-university-libraries/i7-audit/mediause"
+)
+
+const (
+	unresolvedFilesFilePath = "files_unresolved.csv"
+
+	fileColumn     = "file"
+	mediaUseColumn = "media_use_tid"
+	// the datastream in the file column, every other mapped datastream is an additional file
+	fileDsid = "OBJ"
+)
+
+var (
+	files fileFinder
+	// DSID => the i2 media use term workbench gives its media
+	mediaUses = map[string]string{}
+	// additional_files column => DSID
+	additionalFiles = map[string]string{}
+	// pid, dsid, why its file couldn't be found
+	unresolvedFiles = [][]string{}
+)
+
+// fileFinder finds where the datastreams an object has are on disk
+// datastreams the object doesn't have are left out, anything else wrong is reported
+type fileFinder interface {
+	find(pid string, dsids []string) (map[string]string, error)
+}
+
+// akubraFiles reads the paths out of fedora's stores, the same as 030
+type akubraFiles struct {
+	repository akubra.Repository
+}
+
+func (f akubraFiles) find(pid string, dsids []string) (map[string]string, error) {
+	o, err := f.repository.Object(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := map[string]string{}
+	for _, dsid := range dsids {
+		ds, found := o.Datastream(dsid)
+		// inline XML isn't a file, and deleted datastreams aren't migrated
+		if !found || ds.ControlGroup == "X" || ds.State == "D" {
+			continue
+		}
+		v, found := ds.Latest()
+		if !found {
+			unresolvedFiles = append(unresolvedFiles, []string{pid, dsid, "no datastream versions"})
+			continue
+		}
+		path, err := f.repository.ContentPath(v)
+		if err != nil {
+			unresolvedFiles = append(unresolvedFiles, []string{pid, dsid, err.Error()})
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			unresolvedFiles = append(unresolvedFiles, []string{pid, dsid, fmt.Sprintf("%s is missing from the datastream store", path)})
+			continue
+		}
+		paths[dsid] = path
+	}
+
+	return paths, nil
+}
+
+// downloadedFiles are datastreams already downloaded to
+// $dir/$namespace/$DSID/$pid.$extension, the same layout as 001's xml directory
+type downloadedFiles struct {
+	dir string
+}
+
+func (f downloadedFiles) find(pid string, dsids []string) (map[string]string, error) {
+	namespace, _, found := strings.Cut(pid, ":")
+	if !found || namespace == "" {
+		return nil, fmt.Errorf("invalid PID")
+	}
+
+	paths := map[string]string{}
+	for _, dsid := range dsids {
+		matches, err := filepath.Glob(filepath.Join(f.dir, namespace, dsid, pid+".*"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			base := filepath.Base(match)
+			if strings.TrimSuffix(base, filepath.Ext(base)) == pid {
+				paths[dsid] = match
+				break
+			}
+		}
+	}
+
+	return paths, nil
+}
+
+// loadMediaUses reads the DSID => media use mapping, the same one 030 audits with
+// every datastream but OBJ gets an additional_files column named after it
+func loadMediaUses(f string) error {
+	uses, err := mediause.Load(f)
+	if err != nil {
+		return err
+	}
+	if _, found := uses[fileDsid]; !found {
+		return fmt.Errorf("no media use for %s", fileDsid)
+	}
+
+	for dsid, use := range uses {
+		if use.URI == "" {
+			return fmt.Errorf("no media use URI for %s", dsid)
+		}
+		mediaUses[dsid] = use.URI
+		if dsid != fileDsid {
+			additionalFiles[strings.ToLower(dsid)] = dsid
+		}
+	}
+
+	return nil
+}
+
+// fileColumns adds the file, media use and additional_files columns the header doesn't have yet
+func fileColumns(header []string) []string {
+	columns := []string{fileColumn, mediaUseColumn}
+	additional := []string{}
+	for column := range additionalFiles {
+		additional = append(additional, column)
+	}
+	sort.Strings(additional)
+
+	for _, column := range append(columns, additional...) {
+		if !strInSlice(column, header) {
+			header = append(header, column)
+		}
+	}
+
+	return header
+}
+
+// fillFiles writes where an object's files are into its row
+func fillFiles(header, record []string, pid string) {
+	dsids := []string{}
+	for dsid := range mediaUses {
+		dsids = append(dsids, dsid)
+	}
+	sort.Strings(dsids)
+
+	paths, err := files.find(pid, dsids)
+	if err != nil {
+		unresolvedFiles = append(unresolvedFiles, []string{pid, "", err.Error()})
+		return
+	}
+
+	for i, column := range header {
+		switch column {
+		case fileColumn:
+			record[i] = paths[fileDsid]
+		case mediaUseColumn:
+			if paths[fileDsid] != "" {
+				record[i] = mediaUses[fileDsid]
+			}
+		default:
+			if dsid, found := additionalFiles[column]; found {
+				record[i] = paths[dsid]
+			}
+		}
+	}
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lehigh-university-libraries/i7-audit/mods"
+)
+
+var (
+	// how multiple values are written in a CSV cell, see 000's cellValue
+	inputSeparator = ','
+	// written before a separator that's part of a value
+	inputEscape = '\\'
+)
+
+// rowReader reads solr documents as rows of cells
+// every cell is the field's values, so values containing commas survive intact
+type rowReader interface {
+	Header() []string
+	// io.EOF after the last row
+	Read() ([][]string, error)
+	Close() error
+}
+
+// openRows reads a CSV export, or 000's JSON output by the path's extension
+// a directory is read as the solr.N.json pages from 000's crawl
+func openRows(path string) (rowReader, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return readPages(path)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return readJSON(path)
+	case ".ndjson":
+		return readNDJSON(path)
+	}
+
+	return newCsvRows(path)
+}
+
+type csvRows struct {
+	file   *os.File
+	reader *csv.Reader
+	header []string
+}
+
+func newCsvRows(path string) (*csvRows, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(file)
+	header, err := reader.Read()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &csvRows{file: file, reader: reader, header: header}, nil
+}
+
+func (r *csvRows) Header() []string {
+	return append([]string{}, r.header...)
+}
+
+func (r *csvRows) Read() ([][]string, error) {
+	record, err := r.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	row := make([][]string, len(record))
+	for i, cell := range record {
+		row[i] = splitValues(cell)
+	}
+
+	return row, nil
+}
+
+func (r *csvRows) Close() error {
+	return r.file.Close()
+}
+
+// splitValues splits a cell on unescaped separators
+// an escaped separator or escape is kept as part of the value, without the escape
+func splitValues(cell string) []string {
+	if inputSeparator == 0 {
+		if cell == "" {
+			return nil
+		}
+		return []string{cell}
+	}
+
+	values := []string{}
+	var value strings.Builder
+	escaped := false
+	for _, r := range cell {
+		switch {
+		case escaped:
+			if r != inputSeparator && r != inputEscape {
+				value.WriteRune(inputEscape)
+			}
+			value.WriteRune(r)
+			escaped = false
+		case r == inputEscape:
+			escaped = true
+		case r == inputSeparator:
+			values = append(values, value.String())
+			value.Reset()
+		default:
+			value.WriteRune(r)
+		}
+	}
+	if escaped {
+		value.WriteRune(inputEscape)
+	}
+	values = append(values, value.String())
+
+	// an empty cell has no values
+	if len(values) == 1 && values[0] == "" {
+		return nil
+	}
+
+	return values
+}
+
+// docRows are solr documents read into memory
+type docRows struct {
+	header []string
+	docs   []map[string][]string
+	next   int
+}
+
+func (r *docRows) Header() []string {
+	return append([]string{}, r.header...)
+}
+
+func (r *docRows) Read() ([][]string, error) {
+	if r.next >= len(r.docs) {
+		return nil, io.EOF
+	}
+	doc := r.docs[r.next]
+	r.next++
+
+	row := make([][]string, len(r.header))
+	for i, field := range r.header {
+		row[i] = doc[field]
+	}
+
+	return row, nil
+}
+
+func (r *docRows) Close() error {
+	return nil
+}
+
+// add a solr document
+func (r *docRows) add(raw json.RawMessage) error {
+	fields, doc, err := decodeDoc(raw)
+	if err != nil {
+		return err
+	}
+	r.addDoc(fields, doc)
+
+	return nil
+}
+
+// addDoc adds a document, the header is every field in the order it's first seen
+func (r *docRows) addDoc(fields []string, doc map[string][]string) {
+	for _, field := range fields {
+		if !strInSlice(field, r.header) {
+			r.header = append(r.header, field)
+		}
+	}
+	r.docs = append(r.docs, doc)
+}
+
+// the PID goes first, the transform dedupes on it and reports by it
+func (r *docRows) pidFirst() *docRows {
+	for i, field := range r.header {
+		if field == "PID" {
+			r.header = append([]string{field}, append(r.header[:i:i], r.header[i+1:]...)...)
+			break
+		}
+	}
+
+	return r
+}
+
+// decodeDoc reads a solr document keeping the order of its fields
+func decodeDoc(raw json.RawMessage) ([]string, map[string][]string, error) {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	if t, err := d.Token(); err != nil || t != json.Delim('{') {
+		return nil, nil, fmt.Errorf("document isn't an object")
+	}
+
+	fields := []string{}
+	doc := map[string][]string{}
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		field := t.(string)
+
+		var v interface{}
+		if err := d.Decode(&v); err != nil {
+			return nil, nil, err
+		}
+		fields = append(fields, field)
+		doc[field] = docValues(v)
+	}
+
+	return fields, doc, nil
+}
+
+func docValues(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := []string{}
+		for _, e := range v {
+			values = append(values, docValues(e)...)
+		}
+		return values
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+// solrPage is a page of 000's crawl, or a solr response saved by hand
+type solrPage struct {
+	Response struct {
+		Docs []json.RawMessage `json:"docs"`
+	} `json:"response"`
+}
+
+func readPages(dir string) (*docRows, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "solr.*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no solr.*.json pages in %s", dir)
+	}
+	offset := func(p string) int {
+		o := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(p), "solr."), ".json")
+		i, _ := strconv.Atoi(o)
+		return i
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return offset(paths[i]) < offset(paths[j])
+	})
+
+	r := &docRows{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var page solrPage
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for _, raw := range page.Response.Docs {
+			if err := r.add(raw); err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+		}
+	}
+
+	return r.pidFirst(), nil
+}
+
+// readJSON reads the array from 000's trim -format json, or a single solr response
+func readJSON(path string) (*docRows, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []json.RawMessage
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var page solrPage
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, err
+		}
+		docs = page.Response.Docs
+	} else if err := json.Unmarshal(data, &docs); err != nil {
+		return nil, err
+	}
+
+	r := &docRows{}
+	for _, raw := range docs {
+		if err := r.add(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	return r.pidFirst(), nil
+}
+
+// readNDJSON reads 000's trim -format ndjson, a document a line
+func readNDJSON(path string) (*docRows, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := &docRows{}
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) > 0 {
+			if err := r.add(data); err != nil {
+				return nil, fmt.Errorf("%s line %d: %v", path, line, err)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r.pidFirst(), nil
+}
+
+// the RELS-EXT relationships the transform uses, besides each compound's isSequenceNumberOf
+var relsExtPredicates = []string{
+	"hasModel",
+	"isMemberOfCollection",
+	"isMemberOf",
+	"isPageOf",
+	"isConstituentOf",
+	"isSequenceNumber",
+}
+
+// readMods reads the MODS harvested by 001 from $dir/$namespace/MODS/$pid.xml
+// with the parser from 040, along with each object's RELS-EXT from $dir/$namespace/RELS-EXT/$pid.xml
+// the MODS fields are columns named mods.$field, i.e. mods.Names, and RELS-EXT is named the same as in solr
+func readMods(dir string) (*docRows, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "MODS", "*.xml"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no MODS in %s", dir)
+	}
+	sort.Strings(paths)
+
+	// the model is always a column, so objects without one are rejected
+	r := &docRows{header: []string{"PID", "RELS_EXT_hasModel_uri_s"}}
+	for _, path := range paths {
+		pid := strings.TrimSuffix(filepath.Base(path), ".xml")
+		fields := []string{"PID"}
+		doc := map[string][]string{"PID": {pid}}
+
+		relsPath := filepath.Join(filepath.Dir(filepath.Dir(path)), "RELS-EXT", pid+".xml")
+		data, err := os.ReadFile(relsPath)
+		if errors.Is(err, os.ErrNotExist) {
+			rejects = append(rejects, []string{pid, fmt.Sprintf("no RELS-EXT at %s", relsPath)})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		relsFields, rels, err := relsExt(data)
+		if err != nil {
+			rejects = append(rejects, []string{pid, fmt.Sprintf("unable to parse %s: %v", relsPath, err)})
+			continue
+		}
+		fields = append(fields, relsFields...)
+		for field, values := range rels {
+			doc[field] = values
+		}
+
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var m mods.Mods
+		if err := xml.Unmarshal(data, &m); err != nil {
+			rejects = append(rejects, []string{pid, fmt.Sprintf("unable to parse %s: %v", path, err)})
+			continue
+		}
+		v := reflect.ValueOf(m)
+		for i := 0; i < v.NumField(); i++ {
+			elements, ok := v.Field(i).Interface().([]mods.Element)
+			if !ok {
+				continue
+			}
+			field := "mods." + v.Type().Field(i).Name
+			fields = append(fields, field)
+			for _, e := range elements {
+				doc[field] = append(doc[field], e.Value)
+			}
+		}
+
+		r.addDoc(fields, doc)
+	}
+
+	return r, nil
+}
+
+// relsExt reads the relationships in a RELS-EXT the way solr indexes them
+// i.e. RELS_EXT_isPageOf_uri_ms and RELS_EXT_isSequenceNumber_literal_ms
+func relsExt(data []byte) ([]string, map[string][]string, error) {
+	fields := []string{}
+	doc := map[string][]string{}
+	add := func(field, value string) {
+		if value == "" {
+			return
+		}
+		if _, found := doc[field]; !found {
+			fields = append(fields, field)
+		}
+		doc[field] = append(doc[field], value)
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			// rdf:RDF > rdf:Description > the relationships
+			predicate := t.Name.Local
+			if depth != 3 || !strInSlice(predicate, relsExtPredicates) && !strings.HasPrefix(predicate, "isSequenceNumberOf") {
+				continue
+			}
+
+			resource := ""
+			for _, a := range t.Attr {
+				if a.Name.Local == "resource" {
+					resource = a.Value
+				}
+			}
+			switch {
+			case predicate == "hasModel":
+				// every object is a FedoraObject-3.0, the content model is the one that matters
+				if resource != "info:fedora/fedora-system:FedoraObject-3.0" {
+					add("RELS_EXT_hasModel_uri_s", resource)
+				}
+			case resource != "":
+				add("RELS_EXT_"+predicate+"_uri_ms", resource)
+			default:
+				var literal string
+				if err := d.DecodeElement(&literal, &t); err != nil {
+					return nil, nil, err
+				}
+				depth--
+				add("RELS_EXT_"+predicate+"_literal_ms", strings.TrimSpace(literal))
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+
+	return fields, doc, nil
+}
+
+// firstValue is a cell as a single value, i.e. the PID
+func firstValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	return strings.TrimSpace(values[0])
+}
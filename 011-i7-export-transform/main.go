@@ -2,111 +2,161 @@ package main
 
 import (
 	"encoding/csv"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"strconv"
 	"strings"
-)
+	"time"
+	"unicode/utf8"
 
-type IslandoraObject struct {
-	Nid []IntField `json:"nid"`
-}
+	"github.com/lehigh-university-libraries/i7-audit/akubra"
+	"github.com/lehigh-university-libraries/i7-audit/mods"
+	"github.com/lehigh-university-libraries/i7-audit/ratelimit"
+)
 
-type IntField struct {
-	Value int `json:"value"`
-}
+const rejectsFilePath = "rejects.csv"
 
 var (
-	redirectCache          = make(map[string]int)
-	mergedOrDroppedColumns = []string{
-		// field_member_of
-		"RELS_EXT_isConstituentOf_uri_ms",
-		"RELS_EXT_isMemberOfCollection_uri_ms",
-		"RELS_EXT_isMemberOf_uri_ms",
-		"RELS_EXT_isPageOf_uri_ms",
-		// field_linked_agent
-		"dc.creator",
-		"mods_name_creator_namePart_ms",
-		"dc.contributor",
-		"dc.publisher",
-		"mods_name_photographer_namePart_ms",
-		"mods_name_thesis_advisor_namePart_ms",
-		// title
-		"mods_titleInfo_title_all_ms",
-		"mods_titleInfo_title_ms",
-		"dc.title",
-		// field_description
-		"mods_abstract_mt",
-		"dc.description",
-		// field_resource_type
-		"dc.type",
-		"mods_typeOfResource_ms",
-		"mods_typeOfResource_ss",
-		// field_language
-		"dc.language",
-		"mods_language_languageTerm_ms",
-		// field_rights
-		"dc.rights",
-		"mods_accessCondition_use_and_reproduction_ms",
-		// field_edtf_date_created
-		"dc.date",
-		"mods_originInfo_dateCreated_mdt",
-		// field_geographic_subject
-		"mods_subject_authority_naf_geographic_ss",
-		"mods_subject_geographic_ms",
-		"dc.coverage",
-		// field_subject
-		"mods_subject_topic_ms",
-		"dc.subject",
-		// ignored
-		"ID",
-		"file",
-		"mods_part_detail_issue_number_ss",
-		"mods_part_detail_volume_number_ss",
-		// alias of dc.publisher
-		"mods_originInfo_publisher_ms",
-	}
-	agentTypes = map[string]string{}
+	mapping Mapping
+	// pid, why it was left out of the output
+	rejects = [][]string{}
+	// input columns merged into new columns or dropped
+	mergedOrDroppedColumns = []string{}
 )
 
-func init() {
-	// prepopulate the agent types based on a CSV
-	// with two columns: string (i.e. agent) and its linked agent type
-	// i.e. person/corporate_body/family
-	file, err := os.Open("agents.csv")
-	if err != nil {
-		fmt.Println("Error opening CSV file:", err)
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "agents" {
+		agentsCommand(os.Args[2:])
 		return
 	}
-	defer file.Close()
 
-	reader := csv.NewReader(file)
+	mappingPath := flag.String("mapping", "mapping.json", "JSON file describing which columns to rename, drop and merge")
+	flag.BoolVar(&batchAgents, "batch", false, "never prompt for agent types, guess them from the name and record every guess in agents_unresolved.csv")
+	flag.StringVar(&unknownAgentType, "unknown-agent", "fail", "in batch mode, what to do with agents that can't be guessed: fail, or the type to give them (person, corporate_body, family)")
+	pidsPath := flag.String("pids", "", "optional CSV of nid,pid for objects already migrated, i.e. pids.csv, to resolve parents offline")
+	parentURL := flag.String("parent-url", "", "optional URL to look up parents not in -pids, with {pid} replaced, i.e. https://islandora.example.edu/islandora/object/{pid}?_format=json")
+	parentCache := flag.String("parent-cache", "parents-cache.csv", "where parents found with -parent-url are saved for the next run")
+	parentWorkers := flag.Int("parent-workers", 8, "number of parents to look up at once")
+	parentRate := flag.Float64("parent-rate", 10, "maximum parent lookups per second to -parent-url")
+	authorityPath := flag.String("authority", "", "optional LCNAF or VIAF dump, as N-Triples (.nt) or a CSV with uri and heading columns, to reconcile linked agents against")
+	previousPath := flag.String("compare-previous", "", "optional output.csv from an earlier run, to list what changed in changes.csv")
+	schemaPath := flag.String("schema", "", "optional CSV of drupal field definitions from fields.php to validate output.csv against")
+	source := flag.String("source", "solr", "what to build output.csv from: solr, the -input export, or mods, the MODS and RELS-EXT harvested by 001 into -xml")
+	xmlDir := flag.String("xml", "../001-extract-mods/xml", "directory of datastreams harvested by 001, for -source mods")
+	getty := flag.Bool("getty", false, "with -source mods, look up the label of physicalDescription/form values that link to getty AAT instead of keeping the URI")
+	gettyCache := flag.String("getty-cache", "getty-cache.csv", "where labels found with -getty are remembered for later runs")
+	inputFilePath := flag.String("input", "input.csv", "the solr export: a CSV, 000's trim output as .json or .ndjson, or a directory of 000's solr.N.json pages")
+	separator := flag.String("separator", string(inputSeparator), "separates the values of a multi-valued field in a CSV cell, empty if cells are single values")
+	escape := flag.String("escape", string(inputEscape), "written before a separator that's part of a value in a CSV cell")
+	filesFrom := flag.String("files", "", "where to find each object's files for the file, media_use_tid and additional files columns: akubra or downloads. Empty leaves them out")
+	downloadsDir := flag.String("downloads", "downloads", "directory of datastreams downloaded as $namespace/$DSID/$pid.$extension, for -files downloads")
+	objectStore := flag.String("object-store", "/opt/islandora/fedora-objectStore", "fedora's object store, for -files akubra")
+	datastreamStore := flag.String("datastream-store", "/opt/islandora/fedora-datastreamStore", "fedora's datastream store, for -files akubra")
+	mediaUsePath := flag.String("media-use", "", "JSON mapping of DSID to i2 media use, for -files (default the shared mediause/media-use.json)")
+	flag.Parse()
+
+	switch unknownAgentType {
+	case "fail", "person", "corporate_body", "family":
+	default:
+		log.Fatalf("Unknown -unknown-agent %s", unknownAgentType)
+	}
+	if utf8.RuneCountInString(*separator) > 1 || utf8.RuneCountInString(*escape) != 1 {
+		log.Fatal("-separator and -escape need to be a single character")
+	}
+	inputSeparator, _ = utf8.DecodeRuneInString(*separator)
+	if *separator == "" {
+		inputSeparator = 0
+	}
+	inputEscape, _ = utf8.DecodeRuneInString(*escape)
 
-	for {
-		record, err := reader.Read()
+	outputFilePath := "output.csv"
+
+	var err error
+	mapping, err = loadMapping(*mappingPath)
+	if err != nil {
+		log.Fatalf("Unable to load %s: %v", *mappingPath, err)
+	}
+	mergedOrDroppedColumns = mapping.consumed()
+
+	resolvers := chainResolver{}
+	if *pidsPath != "" {
+		r, err := newPidsResolver(*pidsPath)
 		if err != nil {
-			break
+			log.Fatalf("Unable to read %s: %v", *pidsPath, err)
 		}
-		if len(record) == 2 {
-			agentTypes[record[0]] = record[1]
+		resolvers = append(resolvers, r)
+	}
+	if *parentURL != "" {
+		r, err := newCachedResolver(httpResolver{
+			client:      &http.Client{Timeout: time.Minute},
+			urlTemplate: *parentURL,
+			limiter:     ratelimit.NewHostLimiter(*parentRate),
+		}, *parentCache)
+		if err != nil {
+			log.Fatalf("Unable to read %s: %v", *parentCache, err)
 		}
+		resolvers = append(resolvers, r)
 	}
-}
+	if len(resolvers) == 0 {
+		fmt.Println("Warning: no -pids or -parent-url, parents that aren't in the input will be left out of field_member_of")
+	}
+	parents = newMemoResolver(resolvers)
 
-func main() {
-	inputFilePath := "input.csv"
-	outputFilePath := "output.csv"
+	switch *filesFrom {
+	case "":
+	case "akubra":
+		files = akubraFiles{repository: akubra.NewRepository(*objectStore, *datastreamStore)}
+	case "downloads":
+		files = downloadedFiles{dir: *downloadsDir}
+	default:
+		log.Fatalf("Unknown -files %s", *filesFrom)
+	}
+	if files != nil {
+		if err := loadMediaUses(*mediaUsePath); err != nil {
+			log.Fatalf("Unable to read %s: %v", *mediaUsePath, err)
+		}
+	}
+
+	if *schemaPath != "" {
+		schema, err = loadSchema(*schemaPath)
+		if err != nil {
+			log.Fatalf("Unable to load %s: %v", *schemaPath, err)
+		}
+	}
 
-	inputFile, err := os.Open(inputFilePath)
+	if *authorityPath != "" {
+		authority, err = loadAuthority(*authorityPath)
+		if err != nil {
+			log.Fatalf("Unable to load %s: %v", *authorityPath, err)
+		}
+	}
+
+	var rows rowReader
+	switch *source {
+	case "solr":
+		rows, err = openRows(*inputFilePath)
+	case "mods":
+		// every topic is migrated, whatever its authority
+		mods.OtherTopics = true
+		if *getty {
+			mods.Getty, err = mods.NewGettyLookup(*gettyCache)
+			if err != nil {
+				log.Fatalf("Unable to read %s: %v", *gettyCache, err)
+			}
+		}
+		rows, err = readMods(*xmlDir)
+		*inputFilePath = *xmlDir
+	default:
+		log.Fatalf("Unknown -source %s", *source)
+	}
 	if err != nil {
 		fmt.Println("Error opening input file:", err)
 		return
 	}
-	defer inputFile.Close()
+	defer rows.Close()
 
 	outputFile, err := os.Create(outputFilePath)
 	if err != nil {
@@ -115,127 +165,59 @@ func main() {
 	}
 	defer outputFile.Close()
 
-	csvReader := csv.NewReader(inputFile)
 	csvWriter := csv.NewWriter(outputFile)
 
-	columnNames, err := csvReader.Read()
-	if err != nil {
-		fmt.Println("Error reading CSV header:", err)
-		return
+	columnNames := rows.Header()
+
+	// the per-compound sequence numbers only decide the order and weight
+	for _, columnName := range columnNames {
+		if isSequenceNumberOf(columnName) {
+			mergedOrDroppedColumns = append(mergedOrDroppedColumns, columnName)
+		}
 	}
 
-	// Remove the columns to be transformed and add "field_member_of" to the header
+	// Remove the columns to be transformed and rename the rest
 	updatedHeader := []string{}
 	for _, columnName := range columnNames {
 		if strInSlice(columnName, mergedOrDroppedColumns) {
 			continue
 		}
+		updatedHeader = append(updatedHeader, mapping.fieldName(columnName))
+	}
 
-		switch columnName {
-		case "PID":
-			updatedHeader = append(updatedHeader, "field_pid")
-		case "dc.title":
-			updatedHeader = append(updatedHeader, "title")
-		case "RELS_EXT_hasModel_uri_s":
-			updatedHeader = append(updatedHeader, "field_model")
-		case "sequence":
-			updatedHeader = append(updatedHeader, "field_weight")
-		case "mods_name_1_nameIdentifier_orcid_ms":
-			updatedHeader = append(updatedHeader, "field_orcid_num")
-		case "mods_subject_name_personal_namePart_ms":
-			updatedHeader = append(updatedHeader, "field_subjects_name")
-		case "mods_name_creator_affiliation_institution_mt":
-			updatedHeader = append(updatedHeader, "field_affiliated_institution")
-		case "mods_name_creator_affiliation_email_ss":
-			updatedHeader = append(updatedHeader, "field_creator_email")
-		case "RELS_EXT_embargo-expiry-notification-date_literal_s":
-			updatedHeader = append(updatedHeader, columnName)
-		case "RELS_EXT_embargo-expiry-notification-date_literal_ss":
-			updatedHeader = append(updatedHeader, columnName)
-		case "dc.format":
-			updatedHeader = append(updatedHeader, columnName)
-		case "dc.identifier":
-			updatedHeader = append(updatedHeader, "field_identifier")
-		case "dc.relation":
-			updatedHeader = append(updatedHeader, "field_relation")
-		case "dc.source":
-			updatedHeader = append(updatedHeader, "field_source")
-		case "mods_genre_ms":
-			updatedHeader = append(updatedHeader, "field_genre")
-		case "mods_genre_valueURI_ms":
-			updatedHeader = append(updatedHeader, "field_genre_uri")
-		case "mods_identifier_call-number_ms":
-			updatedHeader = append(updatedHeader, "field_call_number")
-		case "mods_identifier_oclc_ms":
-			updatedHeader = append(updatedHeader, "field_oclc_number")
-		case "mods_identifier_uri_displayLabel_ms":
-			updatedHeader = append(updatedHeader, "field_uri_identifier.title")
-		case "mods_identifier_uri_ms":
-			updatedHeader = append(updatedHeader, "field_uri_identifier")
-		case "mods_location_physicalLocation_ms":
-			updatedHeader = append(updatedHeader, "field_physical_location")
-		case "mods_name_corporate_department_namePart_ms":
-			updatedHeader = append(updatedHeader, "field_department_name")
-		case "mods_note_capture_device_ms":
-			updatedHeader = append(updatedHeader, "field_capture_device")
-		case "mods_note_category_ms":
-			updatedHeader = append(updatedHeader, "field_category")
-		case "mods_note_ppi_ms":
-			updatedHeader = append(updatedHeader, "field_ppi")
-		case "mods_note_staff_ms":
-			updatedHeader = append(updatedHeader, "field_staff")
-		case "mods_originInfo_dateCaptured_ms":
-			updatedHeader = append(updatedHeader, "field_date_captured")
-		case "mods_originInfo_dateOther_ms":
-			updatedHeader = append(updatedHeader, "field_edtf_date")
-		case "mods_originInfo_point_end_dateOther_mdt":
-			updatedHeader = append(updatedHeader, "field_end_date")
-		case "mods_originInfo_point_start_dateOther_mdt":
-			updatedHeader = append(updatedHeader, "field_start_date")
-		case "mods_originInfo_type_season_dateOther_ms":
-			updatedHeader = append(updatedHeader, "field_date_season")
-		case "mods_originInfo_type_year_dateOther_ms":
-			updatedHeader = append(updatedHeader, "field_date_other")
-		case "mods_part_detail_issue_number_s":
-			updatedHeader = append(updatedHeader, "field_issue_number")
-		case "mods_part_detail_volume_number_s":
-			updatedHeader = append(updatedHeader, "field_volume_number")
-		case "mods_physicalDescription_digitalOrigin_mt":
-			updatedHeader = append(updatedHeader, "field_digital_origin")
-		case "mods_physicalDescription_extent_ms":
-			updatedHeader = append(updatedHeader, "field_extent")
-		case "mods_physicalDescription_form_ms":
-			updatedHeader = append(updatedHeader, "field_physical_description")
-		case "mods_physicalDescription_form_valueURI_ms":
-			updatedHeader = append(updatedHeader, "field_physical_description_uri")
-		case "mods_physicalDescription_internetMediaType_ms":
-			updatedHeader = append(updatedHeader, "field_media_type")
-		case "mods_relatedItem_host_titleInfo_title_ms":
-			updatedHeader = append(updatedHeader, "field_host")
-		case "mods_relatedItem_original_titleInfo_title_ms":
-			updatedHeader = append(updatedHeader, "field_original_title")
-		default:
-			updatedHeader = append(updatedHeader, columnName)
-		}
-	}
-
-	// the order of this slice matters.
-	// see the calls to merge*() in transformColumns()
-	newColumns := []string{
-		"field_member_of",
-		"title",
-		"field_description",
-		"field_resource_type",
-		"field_language",
-		"field_linked_agent",
-		"field_rights",
-		"field_edtf_date_created",
-		"field_geographic_subject",
-		"field_subject",
+	// warn about sources this export doesn't have
+	// so a typo in the mapping or a projection that left them out doesn't go unnoticed
+	missing := []string{}
+	for _, column := range mergedOrDroppedColumns {
+		if !strInSlice(column, columnNames) {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Printf("Warning: %d columns in %s aren't in %s: %s\n", len(missing), *mappingPath, *inputFilePath, strings.Join(missing, ", "))
+	}
+	for _, rule := range mapping.Merge {
+		found := false
+		for _, source := range rule.Sources {
+			found = found || strInSlice(source, columnNames)
+		}
+		if !found {
+			fmt.Printf("Warning: none of the sources for %s are in %s, so it will always be empty or its default\n", rule.Field, *inputFilePath)
+		}
+	}
+
+	// the merged columns are appended in the order the mapping lists them
+	for _, rule := range mapping.Merge {
+		updatedHeader = append(updatedHeader, rule.Field)
+		columnNames = append(columnNames, rule.Field)
+	}
+	// problems normalizing dates go in a column of their own
+	if len(mapping.EDTF) > 0 {
+		updatedHeader = append(updatedHeader, edtfReportColumn)
 	}
-	for _, newColumn := range newColumns {
-		updatedHeader = append(updatedHeader, newColumn)
-		columnNames = append(columnNames, newColumn)
+	updatedHeader = sequenceColumns(updatedHeader)
+	if files != nil {
+		updatedHeader = fileColumns(updatedHeader)
 	}
 
 	// Write the updated header to the output CSV
@@ -243,35 +225,74 @@ func main() {
 		fmt.Println("Error writing CSV header:", err)
 		return
 	}
+	if schema != nil {
+		schema.validateHeader(updatedHeader)
+	}
 
 	columnIndices := make(map[string]int)
 	for i, name := range columnNames {
 		columnIndices[name] = i
 	}
 
-	pids := map[string]bool{}
+	// every row is read first, parents in the input are created with their children
+	// instead of being looked up
+	records := [][][]string{}
 	for {
-		record, err := csvReader.Read()
+		record, err := rows.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			fmt.Println("Error reading CSV:", err)
+			fmt.Println("Error reading input:", err)
 			return
 		}
 
-		if pids[record[0]] {
+		pid := firstValue(record[0])
+		if inputPids[pid] {
 			continue
 		}
 
-		pids[record[0]] = true
+		inputPids[pid] = true
+		records = append(records, record)
+	}
 
-		transformedRecord := transformColumns(record, columnIndices)
+	// look up every parent before transforming
+	for _, rule := range mapping.Merge {
+		if rule.Field != memberOfColumn {
+			continue
+		}
+		start := time.Now()
+		n := prefetchParents(records, columnIndices, rule.Sources, *parentWorkers)
+		fmt.Printf("Looked up %d parents in %s\n", n, time.Since(start).Round(time.Millisecond))
+	}
 
-		if err := csvWriter.Write(transformedRecord); err != nil {
+	sequenced := []sequencedRow{}
+	for _, record := range records {
+		r := newSequencedRow(record, columnIndices)
+		transformedRecord, err := transformColumns(record, columnIndices)
+		if err != nil {
+			rejects = append(rejects, []string{r.pid, err.Error()})
+			continue
+		}
+		r.record = transformedRecord
+		sequenced = append(sequenced, r)
+	}
+	checkSequences(sequenced)
+
+	// the header is row 1
+	row := 1
+	for _, r := range orderRows(updatedHeader, sequenced) {
+		if files != nil {
+			fillFiles(updatedHeader, r.record, r.pid)
+		}
+		if err := csvWriter.Write(r.record); err != nil {
 			fmt.Println("Error writing CSV:", err)
 			return
 		}
+		row++
+		if schema != nil {
+			schema.validateRow(updatedHeader, r.record, row)
+		}
 	}
 
 	csvWriter.Flush()
@@ -281,420 +302,189 @@ func main() {
 		return
 	}
 
+	if len(unresolvedAgents) > 0 {
+		if err := writeUnresolvedAgents(unresolvedAgentsFilePath); err != nil {
+			log.Fatalf("Error writing %s: %v", unresolvedAgentsFilePath, err)
+		}
+		fmt.Printf("Guessed the type of %d agents, see %s\n", len(unresolvedAgents), unresolvedAgentsFilePath)
+	}
+	if authority != nil {
+		if err := writeAuthorityReports(); err != nil {
+			log.Fatalf("Error writing authority reports: %v", err)
+		}
+		fmt.Printf("Reconciled %d agents against %s, %d need review in %s\n", len(authorityMatches), *authorityPath, len(authorityReviews), authorityReviewFilePath)
+	}
+	if schema != nil {
+		if err := writeCsv(validationFilePath, []string{"pid", "row", "field", "value", "error"}, validationErrors); err != nil {
+			log.Fatalf("Error writing %s: %v", validationFilePath, err)
+		}
+		fmt.Printf("Found %d problems validating %s against %s, see %s\n", len(validationErrors), outputFilePath, *schemaPath, validationFilePath)
+	}
+	if *previousPath != "" {
+		n, err := comparePrevious(*previousPath, outputFilePath)
+		if err != nil {
+			log.Fatalf("Unable to compare with %s: %v", *previousPath, err)
+		}
+		fmt.Printf("Found %d changes since %s, see %s\n", n, *previousPath, changesFilePath)
+	}
+	if len(rejects) > 0 {
+		if err := writeCsv(rejectsFilePath, []string{"pid", "reason"}, rejects); err != nil {
+			log.Fatalf("Error writing %s: %v", rejectsFilePath, err)
+		}
+		fmt.Printf("Left %d objects out of %s, see %s\n", len(rejects), outputFilePath, rejectsFilePath)
+	}
+	if len(sequenceProblems) > 0 {
+		if err := writeCsv(sequenceProblemsFilePath, []string{"parent", "pid", "sequence", "problem"}, sequenceProblems); err != nil {
+			log.Fatalf("Error writing %s: %v", sequenceProblemsFilePath, err)
+		}
+		fmt.Printf("Found %d problems with page and compound sequences, see %s\n", len(sequenceProblems), sequenceProblemsFilePath)
+	}
+	if len(unresolvedFiles) > 0 {
+		if err := writeCsv(unresolvedFilesFilePath, []string{"pid", "dsid", "error"}, unresolvedFiles); err != nil {
+			log.Fatalf("Error writing %s: %v", unresolvedFilesFilePath, err)
+		}
+		fmt.Printf("Unable to find %d files, see %s\n", len(unresolvedFiles), unresolvedFilesFilePath)
+	}
+	if len(unresolvedParents) > 0 {
+		if err := writeCsv(unresolvedParentsFilePath, []string{"pid", "parent", "error"}, unresolvedParents); err != nil {
+			log.Fatalf("Error writing %s: %v", unresolvedParentsFilePath, err)
+		}
+		fmt.Printf("Unable to resolve %d parents, they were left out of field_member_of. See %s\n", len(unresolvedParents), unresolvedParentsFilePath)
+	}
+	if untyped := untypedAgents(); untyped > 0 {
+		outputFile.Close()
+		os.Remove(outputFilePath)
+		log.Fatalf("Unable to guess the type of %d agents. Add them to %s, or pass -unknown-agent with a default type", untyped, agentsFilePath)
+	}
+
 	fmt.Println("CSV transformation complete. Output written to", outputFilePath)
 }
 
-func transformColumns(record []string, columnIndices map[string]int) []string {
-	transformModel(record, columnIndices)
+func transformColumns(record [][]string, columnIndices map[string]int) ([]string, error) {
+	if err := transformModel(record, columnIndices); err != nil {
+		return nil, err
+	}
 	cleanIdentifier(record, columnIndices)
 
-	// the order in which we call these matters since we're appending the CSV header
-	// along with appending the new value in the CSV
-	// TODO: we should consider refactoring to coordinate this instead
-	newRecord := mergeMemberOf(record, columnIndices)
-	newRecord = mergeTitle(newRecord, columnIndices)
-	newRecord = mergeDescription(newRecord, columnIndices)
-	newRecord = mergeType(newRecord, columnIndices)
-	newRecord = mergeLanguage(newRecord, columnIndices)
-	newRecord = mergeLinkedAgent(newRecord, columnIndices)
-	newRecord = mergeRights(newRecord, columnIndices)
-	newRecord = mergeDateCreated(newRecord, columnIndices)
-	newRecord = mergeGeographicSubject(newRecord, columnIndices)
-	newRecord = mergeTopicalSubject(newRecord, columnIndices)
+	newRecord := record
+	for _, rule := range mapping.Merge {
+		newRecord = append(newRecord, rule.merge(newRecord, columnIndices))
+	}
+	memberOfStringToEntityId(newRecord, columnIndices, memberOfColumn)
 
 	// remove the columns we've merged into a single new column
 	hiddenIndices := []int{}
 	for _, column := range mergedOrDroppedColumns {
-		index := columnIndices[column]
+		index, found := columnIndices[column]
+		if !found {
+			continue
+		}
 		hiddenIndices = append(hiddenIndices, index)
 	}
 	transformedRecord := []string{}
+	edtfReport := []string{}
+	// fields drupal only has room for one value in, multiple values are run together
 	singleValueFields := []string{
 		"title",
 		"field_description",
 		"mods_location_physicalLocation_ms",
 	}
-	for k, cell := range newRecord {
+	for k, values := range newRecord {
 		if intInSlice(k, hiddenIndices) {
 			continue
 		}
 
 		field := getFieldName(columnIndices, k)
-		cell = strings.ReplaceAll(cell, "\\,", "<<comma>>")
 
-		if field == "mods_subject_name_personal_namePart_ms" && cell != "" {
-			values := strings.Split(cell, ",")
-			newCell := []string{}
+		if field == "mods_subject_name_personal_namePart_ms" {
+			typed := []string{}
 			for _, v := range values {
 				v = strings.TrimSpace(v)
-				v = strings.ReplaceAll(v, "<<comma>>", ",")
+				if v == "" {
+					continue
+				}
 				cacheAgentType(v)
-				newCell = append(newCell, fmt.Sprintf("%s:%s", agentTypes[v], v))
+				typed = append(typed, fmt.Sprintf("%s:%s", agentTypes[v], v))
 			}
-
-			cell = strings.Join(newCell, "|")
+			values = typed
 		}
-		cell = strings.TrimSpace(cell)
-
-		if strings.Contains(cell, "; ") && !strInSlice(field, singleValueFields) {
-			values := strings.Split(cell, ",")
-			newValues := map[string]bool{}
-			for _, v := range values {
-				v = strings.TrimSpace(v)
-				newValues[v] = true
-			}
+		values = uniqValues(values)
 
-			newCell := []string{}
-			for v, _ := range newValues {
-				newCell = append(newCell, v)
-			}
-			cell = strings.Join(newCell, "|")
+		cell := strings.Join(values, "|")
+		if strInSlice(field, singleValueFields) {
+			cell = strings.Join(values, ", ")
 		}
-		// remove comma separated values from date fields
-		if strings.Contains(field, "date") {
-			values := strings.Split(cell, ",")
-			newValues := map[string]bool{}
-			for _, v := range values {
-				v = strings.TrimSpace(v)
-				newValues[v] = true
-			}
 
-			dates := []string{}
-			for date, _ := range newValues {
-				dates = append(dates, date)
+		if fieldName := mapping.fieldName(field); strInSlice(fieldName, mapping.EDTF) {
+			var problems []string
+			cell, problems = normalizeDates(values)
+			for _, problem := range problems {
+				edtfReport = append(edtfReport, fmt.Sprintf("%s: %s", fieldName, problem))
 			}
-			cell = strings.Join(dates, "|")
 		}
 
-		cell = strings.ReplaceAll(cell, "<<comma>>", ",")
-
 		transformedRecord = append(transformedRecord, cell)
 	}
-
-	return transformedRecord
-}
-
-func transformModel(record []string, columnIndices map[string]int) {
-	column := "RELS_EXT_hasModel_uri_s"
-	index := columnIndices[column]
-	record[index] = getModel(record[index])
-}
-
-func cleanIdentifier(record []string, columnIndices map[string]int) {
-	column := "dc.identifier"
-	index := columnIndices[column]
-	prefixesToIgnore := []string{"islandora:", "digitalcollections:", "preserve:"}
-
-	identifiers := []string{}
-	for _, identifier := range strings.Split(record[index], ",") {
-		if strStartsWith(identifier, prefixesToIgnore) {
-			continue
-		}
-		identifiers = append(identifiers, strings.TrimSpace(identifier))
+	if len(mapping.EDTF) > 0 {
+		transformedRecord = append(transformedRecord, strings.Join(edtfReport, "; "))
 	}
 
-	record[index] = strings.Join(identifiers, "|")
+	return transformedRecord, nil
 }
 
-func mergeTitle(record []string, columnIndices map[string]int) []string {
-	index1, _ := columnIndices["mods_titleInfo_title_all_ms"]
-	index2, _ := columnIndices["mods_titleInfo_title_ms"]
-	index3, _ := columnIndices["dc.title"]
-	if record[index1] != "" {
-		record = append(record, record[index1])
-	} else if record[index2] != "" {
-		record = append(record, record[index2])
-	} else if record[index3] != "" {
-		record = append(record, record[index3])
-	} else {
-		record = append(record, "[Untitled]")
-	}
-
-	return record
-}
-
-func mergeRights(record []string, columnIndices map[string]int) []string {
-	index1, _ := columnIndices["dc.rights"]
-	index2, _ := columnIndices["mods_accessCondition_use_and_reproduction_ms"]
-	if record[index1] != "" {
-		record = append(record, record[index1])
-	} else if record[index2] != "" {
-		record = append(record, record[index2])
-	} else {
-		record = append(record, "")
+func transformModel(record [][]string, columnIndices map[string]int) error {
+	column := "RELS_EXT_hasModel_uri_s"
+	index, found := columnIndices[column]
+	if !found {
+		return nil
 	}
-
-	return record
-}
-
-func mergeType(record []string, columnIndices map[string]int) []string {
-	index1, _ := columnIndices["dc.type"]
-	index2, _ := columnIndices["mods_typeOfResource_ss"]
-	index3, _ := columnIndices["mods_typeOfResource_ms"]
-	if record[index1] != "" {
-		record = append(record, record[index1])
-	} else if record[index2] != "" {
-		record = append(record, record[index2])
-	} else if record[index3] != "" {
-		record = append(record, record[index3])
-	} else {
-		record = append(record, "")
-	}
-
-	return record
-}
-
-func mergeLanguage(record []string, columnIndices map[string]int) []string {
-	index1, _ := columnIndices["dc.language"]
-	index2, _ := columnIndices["mods_language_languageTerm_ms"]
-	if record[index1] != "" {
-		record = append(record, record[index1])
-	} else if record[index2] != "" {
-		record = append(record, record[index2])
-	} else {
-		record = append(record, "")
+	uri := firstValue(record[index])
+	model, found := mapping.Models[uri]
+	if !found {
+		return fmt.Errorf("unknown model %s", uri)
 	}
-
-	return record
-}
-
-func mergeDateCreated(record []string, columnIndices map[string]int) []string {
-	index1, _ := columnIndices["mods_originInfo_dateCreated_mdt"]
-	index2, _ := columnIndices["dc.date"]
-	if record[index1] != "" {
-		record = append(record, record[index1])
-	} else if record[index2] != "" {
-		record = append(record, record[index2])
-	} else {
-		record = append(record, "")
+	if model == "" {
+		return fmt.Errorf("model %s isn't migrated", uri)
 	}
 
-	return record
+	record[index] = []string{model}
+	return nil
 }
 
-func mergeGeographicSubject(record []string, columnIndices map[string]int) []string {
-	fields := map[string]string{
-		"mods_subject_authority_naf_geographic_ss": "geographic_naf",
-		"mods_subject_geographic_ms":               "geo_location",
-		"dc.coverage":                              "geo_location",
-	}
-	subjects := map[string]bool{}
-	for field, vocabulary := range fields {
-		index, _ := columnIndices[field]
-		if strings.TrimSpace(record[index]) == "" {
-			continue
-		}
-
-		delimiter := ","
-		if strings.Contains(record[index], ";") {
-			delimiter = ";"
-		}
-		values := strings.Split(record[index], delimiter)
-		for _, subject := range values {
-			subject = fmt.Sprintf("%s:%s", vocabulary, strings.TrimSpace(subject))
-			subjects[subject] = true
-		}
-	}
-
-	uniqSubjects := []string{}
-	for subject, _ := range subjects {
-		uniqSubjects = append(uniqSubjects, subject)
+func cleanIdentifier(record [][]string, columnIndices map[string]int) {
+	column := "dc.identifier"
+	index, found := columnIndices[column]
+	if !found {
+		return
 	}
+	prefixesToIgnore := []string{"islandora:", "digitalcollections:", "preserve:"}
 
-	record = append(record, strings.Join(uniqSubjects, "|"))
-	return record
-}
-
-func mergeTopicalSubject(record []string, columnIndices map[string]int) []string {
-	fields := []string{
-		"mods_subject_topic_ms",
-	}
-	subjects := map[string]bool{}
-	for _, field := range fields {
-		index, _ := columnIndices[field]
-		if strings.TrimSpace(record[index]) == "" {
+	identifiers := []string{}
+	for _, identifier := range record[index] {
+		if strStartsWith(identifier, prefixesToIgnore) {
 			continue
 		}
-		delimiter := ","
-		if strings.Contains(record[index], ";") {
-			delimiter = ";"
-		}
-		values := strings.Split(record[index], delimiter)
-		for _, subject := range values {
-			subject = strings.TrimSpace(subject)
-			subjects[subject] = true
-		}
-	}
-
-	uniqSubjects := []string{}
-	for subject, _ := range subjects {
-		uniqSubjects = append(uniqSubjects, subject)
+		identifiers = append(identifiers, strings.TrimSpace(identifier))
 	}
 
-	record = append(record, strings.Join(uniqSubjects, "|"))
-	return record
+	record[index] = identifiers
 }
 
-func mergeLinkedAgent(record []string, columnIndices map[string]int) []string {
-	fields := map[string]string{
-		"dc.creator":                           "cre",
-		"dc.contributor":                       "ctb",
-		"dc.publisher":                         "pbl",
-		"mods_name_photographer_namePart_ms":   "pht",
-		"mods_name_thesis_advisor_namePart_ms": "ths",
-	}
-	agents := map[string]bool{}
-	for field, relator := range fields {
-		index, _ := columnIndices[field]
-		if strings.TrimSpace(record[index]) == "" {
+// uniqValues trims values and drops empty values and repeats, keeping the first occurrence
+// so the output is the same on every run
+func uniqValues(values []string) []string {
+	seen := map[string]bool{}
+	uniq := []string{}
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[v] {
 			continue
 		}
-
-		values := strings.Split(record[index], ";")
-		for _, agent := range values {
-			agent = strings.ReplaceAll(agent, "\\,", "<<comma>>")
-			agent = strings.ReplaceAll(agent, "(Creator)", "")
-			agent = strings.ReplaceAll(agent, "(Repository)", "")
-			agent = strings.TrimSpace(agent)
-
-			values := strings.Split(agent, ",")
-			for _, v := range values {
-				if v == "" {
-					continue
-				}
-				v = strings.TrimSpace(v)
-				v = strings.ReplaceAll(v, "<<comma>>", ",")
-				cacheAgentType(v)
-				a := fmt.Sprintf("relators:%s:%s:%s", relator, agentTypes[v], strings.TrimSpace(v))
-				agents[a] = true
-			}
-		}
-	}
-
-	uniqAgents := []string{}
-	for agent, _ := range agents {
-		uniqAgents = append(uniqAgents, agent)
-	}
-
-	record = append(record, strings.Join(uniqAgents, "|"))
-	return record
-}
-
-func mergeDescription(record []string, columnIndices map[string]int) []string {
-	index1, _ := columnIndices["mods_abstract_mt"]
-	index2, _ := columnIndices["dc.description"]
-	if record[index1] != "" {
-		record = append(record, record[index1])
-	} else if record[index2] != "" {
-		record = append(record, record[index2])
-	} else {
-		record = append(record, "")
-	}
-
-	return record
-}
-
-func mergeMemberOf(record []string, columnIndices map[string]int) []string {
-	// merge the various field_member_of columns into a single column
-	index1, _ := columnIndices["RELS_EXT_isMemberOfCollection_uri_ms"]
-	index2, _ := columnIndices["RELS_EXT_isMemberOf_uri_ms"]
-	index3, _ := columnIndices["RELS_EXT_isPageOf_uri_ms"]
-	index4, _ := columnIndices["RELS_EXT_isConstituentOf_uri_ms"]
-	if record[index1] != "" {
-		record = append(record, record[index1])
-	} else if record[index2] != "" {
-		record = append(record, record[index2])
-	} else if record[index3] != "" {
-		record = append(record, record[index3])
-	} else if record[index4] != "" {
-		record = append(record, record[index4])
-	} else {
-		record = append(record, "info:fedora/null")
-	}
-
-	memberOfStringToEntityId(record, columnIndices, "field_member_of")
-
-	return record
-}
-
-func memberOfStringToEntityId(record []string, columnIndices map[string]int, columnName string) {
-	index, found := columnIndices[columnName]
-	if !found {
-		return
-	}
-
-	cell := record[index]
-	cell = strings.ReplaceAll(cell, "info:fedora/", "https://islandora-stage.lib.lehigh.edu/islandora/object/")
-	cell = fmt.Sprintf("%s?_format=json", cell)
-	if number, err := pid2nid(cell); err == nil {
-		record[index] = strconv.Itoa(number)
-	}
-}
-
-func getModel(model string) string {
-	switch model {
-	case "info:fedora/islandora:binaryObjectCModel":
-		return "Binary"
-	case "info:fedora/islandora:bookCModel":
-		return "Paged Content"
-	case "info:fedora/islandora:collectionCModel":
-		return "Sub-Collection"
-	case "info:fedora/islandora:pageCModel":
-		return "Page"
-	case "info:fedora/islandora:sp_basic_image":
-		return "Image"
-	case "info:fedora/islandora:sp_document":
-		return "Binary"
-	case "info:fedora/islandora:sp_large_image_cmodel":
-		return "Image"
-	case "info:fedora/islandora:sp_pdf":
-		return "Digital Document"
-	case "info:fedora/islandora:sp_videoCModel":
-		return "Video"
-	case "info:fedora/islandora:sp_web_archive":
-		return "Binary"
-	}
-
-	log.Fatalf("Missing model: %s", model)
-
-	return ""
-}
-
-func pid2nid(url string) (int, error) {
-	if url == "" {
-		return 0, nil
-	}
-	if cachedNumber, found := redirectCache[url]; found {
-		return cachedNumber, nil
-	}
-	log.Println(url)
-	resp, err := http.Get(url)
-	if err != nil {
-		fmt.Println("Error fetching URL:", err)
-		return 0, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusNotFound {
-		redirectCache[url] = 322431
-		return redirectCache[url], nil
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		log.Fatalf("Unable to find node ID for parent %s", url)
+		seen[v] = true
+		uniq = append(uniq, v)
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatalln("Error reading response body:", err)
-	}
-
-	var node IslandoraObject
-	if err := json.Unmarshal(body, &node); err != nil {
-		log.Fatalln("Error unmarshaling JSON:", err)
-	}
-
-	redirectCache[url] = node.Nid[0].Value
-	return redirectCache[url], nil
+	return uniq
 }
 
 func intInSlice(e int, s []int) bool {
@@ -732,27 +522,3 @@ func getFieldName(m map[string]int, i int) string {
 	}
 	return ""
 }
-
-func cacheAgentType(v string) {
-	_, exists := agentTypes[v]
-	if !exists {
-		fmt.Printf("Enter your choice for %s (corporate_body [c], family [f], or person [p]):\n", v)
-		var input string
-		_, err := fmt.Scanln(&input)
-		if err != nil {
-			fmt.Println("Error reading input:", err)
-			os.Exit(1)
-		}
-
-		input = strings.ToLower(input)
-
-		switch input {
-		case "c":
-			agentTypes[v] = "corporate_body"
-		case "f":
-			agentTypes[v] = "family"
-		default:
-			agentTypes[v] = "person"
-		}
-	}
-}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Mapping describes how a Solr export becomes a workbench CSV
+// columns that aren't renamed, dropped or merged pass through untouched
+type Mapping struct {
+	// Solr column => drupal field
+	Rename map[string]string `json:"rename"`
+	// Solr columns left out of the output entirely
+	Drop []string `json:"drop"`
+	// new columns appended to the output, in order
+	Merge []MergeRule `json:"merge"`
+	// i7 content model => i2 model term
+	// objects with a model that's missing or maps to "" are rejected
+	Models map[string]string `json:"models"`
+	// output fields normalized to EDTF
+	EDTF []string `json:"edtf"`
+}
+
+// MergeRule combines several Solr columns into a single drupal field
+type MergeRule struct {
+	Field string `json:"field"`
+	// in order of precedence
+	Sources []string `json:"sources"`
+	// source column => prefix for its values, i.e. a relator or vocabulary
+	Qualifiers map[string]string `json:"qualifiers,omitempty"`
+	// used when every source is empty
+	Default string `json:"default,omitempty"`
+	// how the sources are combined, see the join* constants
+	Join string `json:"join,omitempty"`
+	// splits each source value further, i.e. dc fields that hold "a; b" as one value
+	Split string `json:"split,omitempty"`
+}
+
+const (
+	// the first non-empty source wins
+	joinFirst = "first"
+	// every value from every source, deduplicated
+	joinUnion = "union"
+	// a union of agent names, written as relators:$qualifier:$type:$name
+	joinLinkedAgent = "linked_agent"
+)
+
+func loadMapping(f string) (Mapping, error) {
+	var m Mapping
+
+	file, err := os.Open(f)
+	if err != nil {
+		return m, err
+	}
+	defer file.Close()
+
+	d := json.NewDecoder(file)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&m); err != nil {
+		return m, err
+	}
+
+	return m, m.validate()
+}
+
+// validate catches mistakes that would otherwise silently shift or clobber columns
+func (m Mapping) validate() error {
+	errs := []string{}
+
+	// every column the mapping consumes, and what consumes it
+	used := map[string]string{}
+	claim := func(column, by string) {
+		if strings.TrimSpace(column) == "" {
+			errs = append(errs, fmt.Sprintf("empty column name in %s", by))
+			return
+		}
+		if other, found := used[column]; found {
+			errs = append(errs, fmt.Sprintf("%s is used by both %s and %s", column, other, by))
+			return
+		}
+		used[column] = by
+	}
+
+	for _, column := range m.Drop {
+		claim(column, "drop")
+	}
+
+	fields := map[string]string{}
+	for column, field := range m.Rename {
+		claim(column, "rename")
+		if strings.TrimSpace(field) == "" {
+			errs = append(errs, fmt.Sprintf("%s is renamed to an empty field", column))
+			continue
+		}
+		if other, found := fields[field]; found {
+			errs = append(errs, fmt.Sprintf("%s and %s are both renamed to %s", column, other, field))
+			continue
+		}
+		fields[field] = column
+	}
+
+	for i, rule := range m.Merge {
+		by := fmt.Sprintf("merge %d (%s)", i, rule.Field)
+		switch rule.Join {
+		case "", joinFirst, joinUnion, joinLinkedAgent:
+		default:
+			errs = append(errs, fmt.Sprintf("%s: unknown join %q", by, rule.Join))
+		}
+		if other, found := fields[rule.Field]; found {
+			errs = append(errs, fmt.Sprintf("%s: %s is also the target of rename %s", by, rule.Field, other))
+		}
+		fields[rule.Field] = by
+		if len(rule.Sources) == 0 {
+			errs = append(errs, fmt.Sprintf("%s: no sources", by))
+		}
+		for _, source := range rule.Sources {
+			claim(source, by)
+		}
+		for source := range rule.Qualifiers {
+			if !strInSlice(source, rule.Sources) {
+				errs = append(errs, fmt.Sprintf("%s: qualifier for %s which isn't a source", by, source))
+			}
+		}
+		// linked agents need a relator for every source
+		if rule.Join == joinLinkedAgent {
+			for _, source := range rule.Sources {
+				if rule.Qualifiers[source] == "" {
+					errs = append(errs, fmt.Sprintf("%s: no relator for %s", by, source))
+				}
+			}
+		}
+	}
+
+	if len(m.Models) == 0 {
+		errs = append(errs, "no models")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid mapping:\n  %s", strings.Join(errs, "\n  "))
+	}
+
+	return nil
+}
+
+// consumed are the input columns that don't pass through to the output
+func (m Mapping) consumed() []string {
+	columns := append([]string{}, m.Drop...)
+	for _, rule := range m.Merge {
+		columns = append(columns, rule.Sources...)
+	}
+
+	return columns
+}
+
+// fieldName is the output header for an input column
+func (m Mapping) fieldName(column string) string {
+	if field, found := m.Rename[column]; found {
+		return field
+	}
+
+	return column
+}
+
+// merge the rule's sources into the values for its field
+func (rule MergeRule) merge(record [][]string, columnIndices map[string]int) []string {
+	var values []string
+	switch rule.Join {
+	case joinUnion:
+		values = rule.union(record, columnIndices)
+	case joinLinkedAgent:
+		values = rule.linkedAgents(record, columnIndices)
+	default:
+		values = rule.first(record, columnIndices)
+	}
+
+	if len(values) == 0 && rule.Default != "" {
+		return []string{rule.Default}
+	}
+
+	return values
+}
+
+// sourceValues are the values of the non-empty source columns in the record, in precedence order
+// each value is split on the rule's split delimiter, if it has one
+func (rule MergeRule) sourceValues(record [][]string, columnIndices map[string]int) ([]string, [][]string) {
+	// first takes a value as it is, only the values being split or combined are trimmed
+	trim := rule.Split != "" || rule.Join == joinUnion || rule.Join == joinLinkedAgent
+
+	sources, values := []string{}, [][]string{}
+	for _, source := range rule.Sources {
+		index, found := columnIndices[source]
+		if !found {
+			continue
+		}
+		sourceValues := []string{}
+		for _, value := range record[index] {
+			parts := []string{value}
+			if rule.Split != "" {
+				parts = strings.Split(value, rule.Split)
+			}
+			for _, v := range parts {
+				if trim {
+					v = strings.TrimSpace(v)
+				}
+				if v != "" {
+					sourceValues = append(sourceValues, v)
+				}
+			}
+		}
+		if len(sourceValues) == 0 {
+			continue
+		}
+		sources = append(sources, source)
+		values = append(values, sourceValues)
+	}
+
+	return sources, values
+}
+
+func (rule MergeRule) first(record [][]string, columnIndices map[string]int) []string {
+	_, values := rule.sourceValues(record, columnIndices)
+	if len(values) == 0 {
+		return nil
+	}
+
+	return values[0]
+}
+
+func (rule MergeRule) union(record [][]string, columnIndices map[string]int) []string {
+	seen := map[string]bool{}
+	merged := []string{}
+	sources, values := rule.sourceValues(record, columnIndices)
+	for i, sourceValues := range values {
+		for _, v := range sourceValues {
+			if qualifier := rule.Qualifiers[sources[i]]; qualifier != "" {
+				v = fmt.Sprintf("%s:%s", qualifier, v)
+			}
+			if seen[v] {
+				continue
+			}
+			seen[v] = true
+			merged = append(merged, v)
+		}
+	}
+
+	return merged
+}
+
+func (rule MergeRule) linkedAgents(record [][]string, columnIndices map[string]int) []string {
+	seen := map[string]bool{}
+	agents := []string{}
+	sources, values := rule.sourceValues(record, columnIndices)
+	for i, sourceValues := range values {
+		relator := rule.Qualifiers[sources[i]]
+		for _, v := range sourceValues {
+			v = strings.ReplaceAll(v, "(Creator)", "")
+			v = strings.ReplaceAll(v, "(Repository)", "")
+			v = strings.TrimSpace(v)
+			if v == "" {
+				continue
+			}
+			cacheAgentType(v)
+			name := reconcileAgent(v, agentTypes[v])
+			a := fmt.Sprintf("relators:%s:%s:%s", relator, agentTypes[v], name)
+			if seen[a] {
+				continue
+			}
+			seen[a] = true
+			agents = append(agents, a)
+		}
+	}
+
+	return agents
+}
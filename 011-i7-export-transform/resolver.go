@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lehigh-university-libraries/i7-audit/ratelimit"
+)
+
+const unresolvedParentsFilePath = "parents_unresolved.csv"
+
+type IslandoraObject struct {
+	Nid []IntField `json:"nid"`
+}
+
+type IntField struct {
+	Value int `json:"value"`
+}
+
+var (
+	errUnresolved = errors.New("no node for PID")
+
+	parents parentResolver
+	// child, parent, why the parent couldn't be resolved
+	unresolvedParents = [][]string{}
+)
+
+// parentResolver finds the drupal node ID an i7 PID was migrated to
+// resolve returns errUnresolved when the PID hasn't been migrated
+type parentResolver interface {
+	resolve(pid string) (int, error)
+}
+
+// pidsResolver looks PIDs up in the nid,pid export also used by 021 and 022
+type pidsResolver struct {
+	nids map[string]int
+}
+
+func newPidsResolver(f string) (pidsResolver, error) {
+	r := pidsResolver{nids: map[string]int{}}
+
+	file, err := os.Open(f)
+	if err != nil {
+		return r, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return r, err
+		}
+		if len(record) < 2 {
+			continue
+		}
+		// skips the header too
+		nid, err := strconv.Atoi(strings.TrimSpace(record[0]))
+		if err != nil {
+			continue
+		}
+		r.nids[strings.TrimSpace(record[1])] = nid
+	}
+
+	return r, nil
+}
+
+func (r pidsResolver) resolve(pid string) (int, error) {
+	if nid, found := r.nids[pid]; found {
+		return nid, nil
+	}
+
+	return 0, errUnresolved
+}
+
+// httpResolver asks a drupal site, which redirects a PID's i7 path to its node
+type httpResolver struct {
+	client *http.Client
+	// i.e. https://islandora.example.edu/islandora/object/{pid}?_format=json
+	urlTemplate string
+	limiter     *ratelimit.HostLimiter
+}
+
+func (r httpResolver) resolve(pid string) (int, error) {
+	u := strings.ReplaceAll(r.urlTemplate, "{pid}", url.PathEscape(pid))
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return 0, err
+	}
+	r.limiter.Wait(parsed.Host)
+
+	resp, err := r.client.Get(u)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, errUnresolved
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("HTTP %d from %s", resp.StatusCode, u)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	var node IslandoraObject
+	if err := json.Unmarshal(body, &node); err != nil {
+		return 0, fmt.Errorf("unable to parse %s: %v", u, err)
+	}
+	if len(node.Nid) == 0 {
+		return 0, fmt.Errorf("no nid in %s", u)
+	}
+
+	return node.Nid[0].Value, nil
+}
+
+// cachedResolver remembers what another resolver found
+// in the same nid,pid format as pids.csv, so later runs don't have to ask again
+type cachedResolver struct {
+	resolver parentResolver
+	path     string
+	mu       sync.Mutex
+	nids     map[string]int
+}
+
+func newCachedResolver(resolver parentResolver, f string) (*cachedResolver, error) {
+	cached, err := newPidsResolver(f)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return &cachedResolver{resolver: resolver, path: f, nids: cached.nids}, nil
+}
+
+func (r *cachedResolver) resolve(pid string) (int, error) {
+	r.mu.Lock()
+	nid, found := r.nids[pid]
+	r.mu.Unlock()
+	if found {
+		return nid, nil
+	}
+
+	nid, err := r.resolver.resolve(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nids[pid] = nid
+	file, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Println("Error opening cache:", err)
+		return nid, nil
+	}
+	defer file.Close()
+	w := csv.NewWriter(file)
+	w.Write([]string{strconv.Itoa(nid), pid})
+	w.Flush()
+
+	return nid, nil
+}
+
+// chainResolver tries each resolver in turn
+type chainResolver []parentResolver
+
+func (c chainResolver) resolve(pid string) (int, error) {
+	err := errUnresolved
+	for _, r := range c {
+		var nid int
+		nid, err = r.resolve(pid)
+		if err == nil {
+			return nid, nil
+		}
+	}
+
+	return 0, err
+}
+
+// memoResolver remembers every answer, including failures, for the rest of the run
+// so each parent is only looked up once however many children it has
+type memoResolver struct {
+	resolver parentResolver
+	mu       sync.Mutex
+	results  map[string]memoResult
+}
+
+type memoResult struct {
+	nid int
+	err error
+}
+
+func newMemoResolver(resolver parentResolver) *memoResolver {
+	return &memoResolver{resolver: resolver, results: map[string]memoResult{}}
+}
+
+func (r *memoResolver) resolve(pid string) (int, error) {
+	r.mu.Lock()
+	result, found := r.results[pid]
+	r.mu.Unlock()
+	if found {
+		return result.nid, result.err
+	}
+
+	result.nid, result.err = r.resolver.resolve(pid)
+	r.mu.Lock()
+	r.results[pid] = result
+	r.mu.Unlock()
+
+	return result.nid, result.err
+}
+
+// parentPids are the parents in a list of RELS_EXT URIs
+func parentPids(uris []string) []string {
+	pids := []string{}
+	for _, parent := range uris {
+		pid := strings.TrimPrefix(strings.TrimSpace(parent), "info:fedora/")
+		// info:fedora/null is an object with no parent
+		if pid == "" || pid == "null" {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+
+	return pids
+}
+
+// prefetchParents resolves every distinct parent in the input before transforming it
+// so slow lookups happen concurrently instead of one row at a time
+func prefetchParents(records [][][]string, columnIndices map[string]int, columns []string, workers int) int {
+	seen := map[string]bool{}
+	pids := []string{}
+	for _, record := range records {
+		for _, column := range columns {
+			index, found := columnIndices[column]
+			if !found {
+				continue
+			}
+			for _, pid := range parentPids(record[index]) {
+				// created in the same run, see orderRows
+				if seen[pid] || inputPids[pid] {
+					continue
+				}
+				seen[pid] = true
+				pids = append(pids, pid)
+			}
+		}
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	ch := make(chan string)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for pid := range ch {
+				parents.resolve(pid)
+			}
+		}()
+	}
+	for _, pid := range pids {
+		ch <- pid
+	}
+	close(ch)
+	wg.Wait()
+
+	return len(pids)
+}
+
+// memberOfStringToEntityId replaces the parent PIDs in a column with their node IDs
+// parents that can't be resolved are left out and reported
+// parents in the input are kept as PIDs until orderRows knows which of them were written
+func memberOfStringToEntityId(record [][]string, columnIndices map[string]int, columnName string) {
+	index, found := columnIndices[columnName]
+	if !found {
+		return
+	}
+
+	nids := []string{}
+	for _, pid := range parentPids(record[index]) {
+		if inputPids[pid] {
+			nids = append(nids, pid)
+			continue
+		}
+		nid, err := parents.resolve(pid)
+		if err != nil {
+			unresolvedParents = append(unresolvedParents, []string{firstValue(record[0]), pid, err.Error()})
+			continue
+		}
+		nids = append(nids, strconv.Itoa(nid))
+	}
+
+	record[index] = nids
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const validationFilePath = "validation.csv"
+
+// columns workbench understands that aren't drupal fields
+var workbenchColumns = []string{
+	"id",
+	"parent_id",
+	"node_id",
+	"file",
+	"media_use_tid",
+	"url_alias",
+	"image_alt_text",
+	"checksum",
+	"published",
+}
+
+// fieldDefinition is a row of the fields.php export
+type fieldDefinition struct {
+	Name string
+	Type string
+	// -1 is unlimited
+	Cardinality int
+	TargetType  string
+	// vocabularies, for taxonomy term references
+	TargetBundles []string
+}
+
+// fieldSchema is the drupal fields output.csv is checked against
+type fieldSchema map[string]fieldDefinition
+
+var (
+	schema fieldSchema
+	// pid, output row, field, value, error
+	validationErrors = [][]string{}
+)
+
+func loadSchema(f string) (fieldSchema, error) {
+	file, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, column := range header {
+		columns[column] = i
+	}
+	for _, column := range []string{"field_name", "type", "cardinality", "target_type", "target_bundles"} {
+		if _, found := columns[column]; !found {
+			return nil, fmt.Errorf("missing column %s", column)
+		}
+	}
+
+	s := fieldSchema{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		cardinality, err := strconv.Atoi(record[columns["cardinality"]])
+		if err != nil {
+			return nil, fmt.Errorf("bad cardinality for %s: %v", record[columns["field_name"]], err)
+		}
+		d := fieldDefinition{
+			Name:        record[columns["field_name"]],
+			Type:        record[columns["type"]],
+			Cardinality: cardinality,
+			TargetType:  record[columns["target_type"]],
+		}
+		if bundles := record[columns["target_bundles"]]; bundles != "" {
+			d.TargetBundles = strings.Split(bundles, "|")
+		}
+		s[d.Name] = d
+	}
+
+	return s, nil
+}
+
+// validateHeader reports columns drupal and workbench won't know what to do with
+func (s fieldSchema) validateHeader(header []string) {
+	for _, column := range header {
+		if _, found := s[column]; found || strInSlice(column, workbenchColumns) || column == edtfReportColumn {
+			continue
+		}
+		if _, found := additionalFiles[column]; found {
+			continue
+		}
+		validationErrors = append(validationErrors, []string{"", "1", column, "", "unknown column"})
+	}
+}
+
+// validateRow reports every problem workbench would have with a row of output.csv
+func (s fieldSchema) validateRow(header, record []string, row int) {
+	report := func(field, value, format string, args ...interface{}) {
+		validationErrors = append(validationErrors, []string{record[0], strconv.Itoa(row), field, value, fmt.Sprintf(format, args...)})
+	}
+
+	for i, cell := range record {
+		d, found := s[header[i]]
+		if !found || cell == "" {
+			continue
+		}
+
+		values := strings.Split(cell, "|")
+		if d.Cardinality > 0 && len(values) > d.Cardinality {
+			report(d.Name, cell, "%d values but the field allows %d", len(values), d.Cardinality)
+		}
+
+		for _, v := range values {
+			if err := d.validateValue(v); err != nil {
+				report(d.Name, v, "%v", err)
+			}
+		}
+	}
+}
+
+func (d fieldDefinition) validateValue(v string) error {
+	if strings.TrimSpace(v) == "" {
+		return fmt.Errorf("empty value")
+	}
+
+	switch d.Type {
+	case "integer":
+		if _, err := strconv.Atoi(v); err != nil {
+			return fmt.Errorf("not an integer")
+		}
+	case "decimal", "float":
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return fmt.Errorf("not a number")
+		}
+	case "typed_relation":
+		// namespace:role:target
+		parts := strings.SplitN(v, ":", 3)
+		if len(parts) < 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return fmt.Errorf("not a namespace:role:target typed relation")
+		}
+		return d.validateReference(parts[2])
+	case "entity_reference":
+		return d.validateReference(v)
+	}
+
+	return nil
+}
+
+// validateReference checks a node ID, term ID, or term name
+// references to more than one vocabulary need the name prefixed with vocab:
+func (d fieldDefinition) validateReference(v string) error {
+	if _, err := strconv.Atoi(v); err == nil {
+		return nil
+	}
+
+	switch d.TargetType {
+	case "node", "media", "user":
+		return fmt.Errorf("%s reference isn't an ID", d.TargetType)
+	case "taxonomy_term":
+		if len(d.TargetBundles) < 2 {
+			return nil
+		}
+		vocab, name, found := strings.Cut(v, ":")
+		if !found {
+			return fmt.Errorf("missing vocabulary prefix, one of %s", strings.Join(d.TargetBundles, ", "))
+		}
+		if !strInSlice(vocab, d.TargetBundles) {
+			return fmt.Errorf("vocabulary %q isn't one of %s", vocab, strings.Join(d.TargetBundles, ", "))
+		}
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("empty term name")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	sequenceProblemsFilePath = "sequence_problems.csv"
+
+	pageOfColumn        = "RELS_EXT_isPageOf_uri_ms"
+	constituentOfColumn = "RELS_EXT_isConstituentOf_uri_ms"
+	// a page's position in its book
+	sequenceNumberColumn = "RELS_EXT_isSequenceNumber_literal_ms"
+	// a compound child's position in each compound, i.e. RELS_EXT_isSequenceNumberOfdemo_compound_literal_ms
+	sequenceNumberOfPrefix = "RELS_EXT_isSequenceNumberOf"
+	sequenceNumberOfSuffix = "_literal_ms"
+
+	memberOfColumn = "field_member_of"
+	weightColumn   = "field_weight"
+	idColumn       = "id"
+	parentIdColumn = "parent_id"
+)
+
+var (
+	// every PID in the input, parents among them that aren't rejected are created in the same run
+	inputPids = map[string]bool{}
+	// parent, child, sequence number, problem
+	sequenceProblems = [][]string{}
+)
+
+// sequencedRow is a transformed row waiting to be written in order
+type sequencedRow struct {
+	pid string
+	// the parent this row is a page or compound child of, in this run or not
+	sequenceParent string
+	sequence       string
+	record         []string
+}
+
+// isSequenceNumberOf is true for the per-compound sequence columns, which are read by position
+func isSequenceNumberOf(column string) bool {
+	return strings.HasPrefix(column, sequenceNumberOfPrefix) && strings.HasSuffix(column, sequenceNumberOfSuffix)
+}
+
+// newSequencedRow reads where an input row goes before it's transformed
+func newSequencedRow(record [][]string, columnIndices map[string]int) sequencedRow {
+	row := sequencedRow{pid: firstValue(record[0])}
+	value := func(column string) []string {
+		index, found := columnIndices[column]
+		if !found {
+			return nil
+		}
+		return record[index]
+	}
+
+	if books := parentPids(value(pageOfColumn)); len(books) > 0 {
+		row.sequenceParent = books[0]
+		row.sequence = firstValue(value(sequenceNumberColumn))
+	} else if compounds := parentPids(value(constituentOfColumn)); len(compounds) > 0 {
+		row.sequenceParent = compounds[0]
+		column := sequenceNumberOfPrefix + strings.ReplaceAll(compounds[0], ":", "_") + sequenceNumberOfSuffix
+		row.sequence = firstValue(value(column))
+	}
+
+	return row
+}
+
+// checkSequences reports pages and compound children with no sequence number,
+// and gaps and duplicates among the children of each parent
+func checkSequences(rows []sequencedRow) {
+	children := map[string][]sequencedRow{}
+	order := []string{}
+	for _, row := range rows {
+		if row.sequenceParent == "" {
+			continue
+		}
+		if _, found := children[row.sequenceParent]; !found {
+			order = append(order, row.sequenceParent)
+		}
+		children[row.sequenceParent] = append(children[row.sequenceParent], row)
+	}
+
+	report := func(parent, pid, sequence, format string, args ...interface{}) {
+		sequenceProblems = append(sequenceProblems, []string{parent, pid, sequence, fmt.Sprintf(format, args...)})
+	}
+	for _, parent := range order {
+		used := map[int]string{}
+		numbers := []int{}
+		for _, child := range children[parent] {
+			if child.sequence == "" {
+				report(parent, child.pid, "", "no sequence number")
+				continue
+			}
+			n, err := strconv.Atoi(child.sequence)
+			if err != nil || n < 1 {
+				report(parent, child.pid, child.sequence, "not a sequence number")
+				continue
+			}
+			if other, found := used[n]; found {
+				report(parent, child.pid, child.sequence, "also used by %s", other)
+				continue
+			}
+			used[n] = child.pid
+			numbers = append(numbers, n)
+		}
+
+		sort.Ints(numbers)
+		next := 1
+		for _, n := range numbers {
+			switch {
+			case n == next+1:
+				report(parent, "", strconv.Itoa(next), "missing")
+			case n > next:
+				report(parent, "", fmt.Sprintf("%d-%d", next, n-1), "missing")
+			}
+			next = n + 1
+		}
+	}
+}
+
+// orderRows puts every parent before its children, so workbench can create them in one pass
+// children follow their parent in sequence order, everything else keeps its input order
+func orderRows(header []string, rows []sequencedRow) []sequencedRow {
+	written := map[string]bool{}
+	for _, row := range rows {
+		written[row.pid] = true
+	}
+
+	children := map[string][]int{}
+	roots := []int{}
+	for i := range rows {
+		parent := rows[i].inputParent(header, written)
+		rows[i].fill(header, parent)
+		if parent == "" {
+			roots = append(roots, i)
+			continue
+		}
+		children[parent] = append(children[parent], i)
+	}
+
+	ordered := []sequencedRow{}
+	placed := map[int]bool{}
+	var place func(i int)
+	place = func(i int) {
+		if placed[i] {
+			return
+		}
+		placed[i] = true
+		ordered = append(ordered, rows[i])
+
+		siblings := children[rows[i].pid]
+		sort.SliceStable(siblings, func(a, b int) bool {
+			return sequenceKey(rows[siblings[a]]) < sequenceKey(rows[siblings[b]])
+		})
+		for _, child := range siblings {
+			place(child)
+		}
+	}
+	for _, i := range roots {
+		place(i)
+	}
+
+	// rows that are their own ancestors, which i7 shouldn't allow
+	for i, row := range rows {
+		if !placed[i] {
+			sequenceProblems = append(sequenceProblems, []string{"", row.pid, "", "its parents form a loop"})
+			place(i)
+		}
+	}
+
+	return ordered
+}
+
+// sequenceKey sorts siblings by sequence number, with anything unnumbered last
+func sequenceKey(row sequencedRow) int {
+	n, err := strconv.Atoi(row.sequence)
+	if err != nil || n < 1 {
+		return int(^uint(0) >> 1)
+	}
+
+	return n
+}
+
+// sequenceColumns adds the columns sequencing fills in that the header doesn't have yet
+func sequenceColumns(header []string) []string {
+	for _, column := range []string{weightColumn, idColumn, parentIdColumn} {
+		if !strInSlice(column, header) {
+			header = append(header, column)
+		}
+	}
+
+	return header
+}
+
+// inputParent settles the parents in the input that memberOfStringToEntityId left in field_member_of
+// the first one written is the row's parent_id, which workbench sets field_member_of from,
+// so it only comes from the source field_member_of took, i.e. a page's book before any collection
+// parents that were rejected are looked up like any other
+func (row *sequencedRow) inputParent(header []string, written map[string]bool) string {
+	index := -1
+	for i, column := range header {
+		if column == memberOfColumn {
+			index = i
+		}
+	}
+	if index < 0 || index >= len(row.record) || row.record[index] == "" {
+		return ""
+	}
+
+	parent := ""
+	values := []string{}
+	for _, v := range strings.Split(row.record[index], "|") {
+		switch {
+		case !inputPids[v]:
+			values = append(values, v)
+		case written[v] && parent == "":
+			parent = v
+		case written[v]:
+			unresolvedParents = append(unresolvedParents, []string{row.pid, v, fmt.Sprintf("parent_id is already %s", parent)})
+		default:
+			nid, err := parents.resolve(v)
+			if err != nil {
+				unresolvedParents = append(unresolvedParents, []string{row.pid, v, fmt.Sprintf("parent left out of the output, %v", err)})
+				continue
+			}
+			values = append(values, strconv.Itoa(nid))
+		}
+	}
+	row.record[index] = strings.Join(values, "|")
+	// workbench replaces field_member_of with parent_id, so any other parent is lost
+	if parent != "" {
+		for _, v := range values {
+			unresolvedParents = append(unresolvedParents, []string{row.pid, v, fmt.Sprintf("parent_id %s replaces it in field_member_of", parent)})
+		}
+	}
+
+	return parent
+}
+
+// fill in the row's id, parent_id and, when it has a sequence number, its weight
+func (row *sequencedRow) fill(header []string, parent string) {
+	for len(row.record) < len(header) {
+		row.record = append(row.record, "")
+	}
+	for i, column := range header {
+		switch column {
+		case idColumn:
+			row.record[i] = row.pid
+		case parentIdColumn:
+			row.record[i] = parent
+		case weightColumn:
+			if n, err := strconv.Atoi(row.sequence); err == nil && n > 0 {
+				row.record[i] = strconv.Itoa(n)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// audited is every file report for a single PID,
+// written to the checkpoint as soon as the PID is done
+type audited struct {
+	Pid        string       `json:"pid"`
+	Algorithms []string     `json:"algorithms"`
+	Files      []fileReport `json:"files"`
+}
+
+// readCheckpoint reads the PIDs an earlier run already audited
+// a line cut short by a crash is dropped, so that PID is audited again
+// openCheckpoint ends it with a newline, so it's still on a line of its own after a resume
+func readCheckpoint(f string, algorithms []string) (map[string]bool, []fileReport, error) {
+	done := map[string]bool{}
+	reports := []fileReport{}
+
+	file, err := os.Open(f)
+	if errors.Is(err, os.ErrNotExist) {
+		return done, reports, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var a audited
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			continue
+		}
+		if strings.Join(a.Algorithms, ",") != strings.Join(algorithms, ",") {
+			return nil, nil, fmt.Errorf("%s was checksummed with %s, not %s, remove the checkpoint to start over", a.Pid, strings.Join(a.Algorithms, ","), strings.Join(algorithms, ","))
+		}
+		done[a.Pid] = true
+		reports = append(reports, a.Files...)
+	}
+
+	return done, reports, scanner.Err()
+}
+
+// openCheckpoint opens the checkpoint to add to it
+// a last line cut short by a crash gets a newline, so the next record isn't glued onto it
+func openCheckpoint(f string) (*os.File, error) {
+	file, err := os.OpenFile(f, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if end := info.Size(); end > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, end-1); err != nil {
+			file.Close()
+			return nil, err
+		}
+		if last[0] != '\n' {
+			if _, err := file.Write([]byte("\n")); err != nil {
+				file.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return file, nil
+}
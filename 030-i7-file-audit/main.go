@@ -0,0 +1,538 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lehigh-university-libraries/i7-audit/akubra"
+	"github.com/lehigh-university-libraries/i7-audit/mediause"
+)
+
+type fileReport struct {
+	Pid           string            `json:"pid"`
+	Dsid          string            `json:"dsid"`
+	MediaUse      string            `json:"media_use"`
+	Version       string            `json:"version"`
+	MimeType      string            `json:"mime_type"`
+	Size          int64             `json:"size"`
+	Path          string            `json:"path"`
+	Checksums     map[string]string `json:"checksums"`
+	FedoraDigest  string            `json:"fedora_digest,omitempty"`
+	FedoraFixity  string            `json:"fedora_fixity,omitempty"`
+	InI2          string            `json:"in_i2,omitempty"`
+	ChecksumMatch string            `json:"checksum_match,omitempty"`
+	Error         string            `json:"error,omitempty"`
+}
+
+// outcomes of checking a file against the digest fedora recorded for it
+const (
+	fixityOk          = "ok"
+	fixityMismatch    = "mismatch"
+	fixityDisabled    = "disabled"
+	fixityNone        = "none"
+	fixityUnsupported = "unsupported"
+)
+
+// i2Files is the sha1 of every i2 file by PID then media use
+type i2Files map[string]map[string][]string
+
+var (
+	hashes = map[string]func() hash.Hash{
+		"md5":    md5.New,
+		"sha1":   sha1.New,
+		"sha256": sha256.New,
+		"sha384": sha512.New384,
+		"sha512": sha512.New,
+	}
+
+	// FOXML contentDigest TYPE to the algorithm in hashes
+	fedoraDigests = map[string]string{
+		"MD5":     "md5",
+		"SHA-1":   "sha1",
+		"SHA-256": "sha256",
+		"SHA-384": "sha384",
+		"SHA-512": "sha512",
+	}
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "audit":
+		audit(os.Args[2:])
+	case "reconcile":
+		reconcile(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Println("Usage: go run *.go audit|reconcile [flags]")
+	os.Exit(1)
+}
+
+func audit(args []string) {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	pidsPath := fs.String("pids", "pids.csv", "file with a PID in the first tab separated column of every line")
+	objectStore := fs.String("object-store", "/opt/islandora/fedora-objectStore", "fedora's object store")
+	datastreamStore := fs.String("datastream-store", "/opt/islandora/fedora-datastreamStore", "fedora's datastream store")
+	algorithms := fs.String("algorithms", "sha1", "comma separated list of checksums to report: md5, sha1, sha256, sha384, sha512")
+	format := fs.String("format", "tsv", "report format: tsv or json")
+	outputPath := fs.String("o", "i7.tsv", "report file")
+	workers := fs.Int("workers", 8, "number of files to hash at once")
+	mediaUsePath := fs.String("media-use", "", "JSON mapping of DSID to i2 media use (default the shared mediause/media-use.json)")
+	all := fs.Bool("all", false, "also audit managed datastreams that have no media use mapping")
+	i2Path := fs.String("i2", "", "optional TSV of pid, media use and sha1 exported from i2 to compare against")
+	unverifiablePath := fs.String("unverifiable", "unverifiable.tsv", "files fedora has no usable digest for")
+	checkpointPath := fs.String("checkpoint", "checkpoint.ndjson", "PIDs audited so far, so an interrupted audit carries on where it stopped")
+	fs.Parse(args)
+
+	algos := []string{}
+	for _, algo := range strings.Split(*algorithms, ",") {
+		algo = strings.TrimSpace(algo)
+		if _, ok := hashes[algo]; !ok {
+			log.Fatalf("Unknown checksum algorithm %q", algo)
+		}
+		algos = append(algos, algo)
+	}
+
+	mediaUses, err := readMediaUses(*mediaUsePath)
+	if err != nil {
+		log.Fatalf("Unable to read %s: %v", *mediaUsePath, err)
+	}
+
+	var i2 i2Files
+	if *i2Path != "" {
+		i2, err = readI2Files(*i2Path)
+		if err != nil {
+			log.Fatalf("Unable to read %s: %v", *i2Path, err)
+		}
+		// i2 only records a sha1
+		if !strInSlice("sha1", algos) {
+			algos = append(algos, "sha1")
+		}
+	}
+
+	pids, err := readPids(*pidsPath)
+	if err != nil {
+		log.Fatalf("Unable to read %s: %v", *pidsPath, err)
+	}
+
+	done, reports, err := readCheckpoint(*checkpointPath, algos)
+	if err != nil {
+		log.Fatalf("Unable to read %s: %v", *checkpointPath, err)
+	}
+	todo := []string{}
+	for _, pid := range pids {
+		if !done[pid] {
+			todo = append(todo, pid)
+		}
+	}
+	if len(done) > 0 {
+		log.Printf("Resuming from %s, %d of %d PIDs already audited", *checkpointPath, len(pids)-len(todo), len(pids))
+	}
+	checkpoint, err := openCheckpoint(*checkpointPath)
+	if err != nil {
+		log.Fatalf("Unable to open %s: %v", *checkpointPath, err)
+	}
+	enc := json.NewEncoder(checkpoint)
+
+	a := auditor{
+		repository: akubra.NewRepository(*objectStore, *datastreamStore),
+		algorithms: algos,
+		mediaUses:  mediaUses,
+		all:        *all,
+	}
+
+	ch := make(chan string)
+	results := make(chan audited)
+	var wg sync.WaitGroup
+	wg.Add(*workers)
+	for i := 0; i < *workers; i++ {
+		go func() {
+			defer wg.Done()
+			for pid := range ch {
+				results <- audited{Pid: pid, Algorithms: algos, Files: a.audit(pid)}
+			}
+		}()
+	}
+	go func() {
+		for _, pid := range todo {
+			ch <- pid
+		}
+		close(ch)
+		wg.Wait()
+		close(results)
+	}()
+
+	// every PID is checkpointed as soon as it's done, so a crash only loses the files being hashed
+	count := len(pids) - len(todo)
+	for r := range results {
+		if err := enc.Encode(r); err != nil {
+			log.Fatalf("Error writing %s: %v", *checkpointPath, err)
+		}
+		reports = append(reports, r.Files...)
+		count++
+		if count%1000 == 0 {
+			log.Printf("Audited %d of %d PIDs", count, len(pids))
+		}
+	}
+	checkpoint.Close()
+
+	// keep the report diffable between runs
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Pid != reports[j].Pid {
+			return reports[i].Pid < reports[j].Pid
+		}
+		return reports[i].Dsid < reports[j].Dsid
+	})
+
+	if i2 != nil {
+		for k := range reports {
+			i2.compare(&reports[k])
+		}
+	}
+
+	fixity := map[string]int{}
+	for _, r := range reports {
+		if r.FedoraFixity != "" {
+			fixity[r.FedoraFixity]++
+		}
+	}
+	for _, status := range []string{fixityOk, fixityMismatch, fixityDisabled, fixityNone, fixityUnsupported} {
+		fmt.Printf("fedora digest %-12s %d\n", status, fixity[status])
+	}
+	if err := writeUnverifiable(*unverifiablePath, reports); err != nil {
+		log.Fatalf("Error writing %s: %v", *unverifiablePath, err)
+	}
+
+	outputFile, err := os.Create(*outputPath)
+	if err != nil {
+		log.Fatalf("Error creating output file: %v", err)
+	}
+	defer outputFile.Close()
+
+	switch *format {
+	case "tsv":
+		err = writeTsv(outputFile, reports, algos)
+	case "json":
+		enc := json.NewEncoder(outputFile)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(reports)
+	default:
+		log.Fatalf("Unknown format %s", *format)
+	}
+	if err != nil {
+		log.Fatalf("Error writing report: %v", err)
+	}
+	// the report has everything the checkpoint did, so the next audit starts fresh
+	if err := os.Remove(*checkpointPath); err != nil {
+		log.Printf("Unable to remove %s: %v", *checkpointPath, err)
+	}
+
+	fmt.Println("File audit complete. Output written to", *outputPath)
+}
+
+// readPids takes the first tab separated column of every line
+func readPids(f string) ([]string, error) {
+	file, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comma = '\t'
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	pids := []string{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		pid := strings.TrimSpace(record[0])
+		if pid == "" || pid == "pid" {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+
+	return pids, nil
+}
+
+// readMediaUses reads the DSID to i2 media use name mapping
+func readMediaUses(f string) (map[string]string, error) {
+	uses, err := mediause.Load(f)
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[string]string{}
+	for dsid, use := range uses {
+		if use.Name == "" {
+			return nil, fmt.Errorf("no media use name for %s", dsid)
+		}
+		m[dsid] = use.Name
+	}
+
+	return m, nil
+}
+
+// readI2Files reads the pid, media use, sha1 TSV exported from i2
+func readI2Files(f string) (i2Files, error) {
+	file, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comma = '\t'
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	files := i2Files{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 || record[0] == "pid" {
+			continue
+		}
+		pid, mediaUse, sha1 := record[0], record[1], strings.ToLower(record[2])
+		if files[pid] == nil {
+			files[pid] = map[string][]string{}
+		}
+		files[pid][mediaUse] = append(files[pid][mediaUse], sha1)
+	}
+
+	return files, nil
+}
+
+// compare an i7 file against the i2 files with the same PID and media use
+func (i2 i2Files) compare(r *fileReport) {
+	if r.MediaUse == "" || r.Error != "" {
+		return
+	}
+
+	sums, found := i2[r.Pid][r.MediaUse]
+	if !found {
+		r.InI2 = "no"
+		return
+	}
+
+	r.InI2 = "yes"
+	r.ChecksumMatch = "no"
+	if strInSlice(r.Checksums["sha1"], sums) {
+		r.ChecksumMatch = "yes"
+	}
+}
+
+type auditor struct {
+	repository akubra.Repository
+	algorithms []string
+	mediaUses  map[string]string
+	all        bool
+}
+
+// audit the latest version of every managed datastream
+// that has an i2 media use (or all of them with -all)
+func (a auditor) audit(pid string) []fileReport {
+	o, err := a.repository.Object(pid)
+	if err != nil {
+		return []fileReport{{Pid: pid, Error: err.Error()}}
+	}
+
+	reports := []fileReport{}
+	for _, ds := range o.Datastreams {
+		// inline XML isn't a file, and deleted datastreams weren't migrated
+		if ds.ControlGroup == "X" || ds.State == "D" {
+			continue
+		}
+		mediaUse, mapped := a.mediaUses[ds.ID]
+		if !mapped && !a.all {
+			continue
+		}
+
+		r := fileReport{
+			Pid:       pid,
+			Dsid:      ds.ID,
+			MediaUse:  mediaUse,
+			Checksums: map[string]string{},
+		}
+		v, found := ds.Latest()
+		if !found {
+			r.Error = fmt.Sprintf("no %s datastream versions", r.Dsid)
+			reports = append(reports, r)
+			continue
+		}
+		r.Version = v.ID
+		r.MimeType = v.MimeType
+
+		r.Path, err = a.repository.ContentPath(v)
+		if err != nil {
+			r.Error = err.Error()
+			reports = append(reports, r)
+			continue
+		}
+
+		// hash with whatever fedora used too, in the same read
+		algorithms := a.algorithms
+		fedoraAlgo := ""
+		r.FedoraFixity = fixityNone
+		if v.ContentDigest != nil && v.ContentDigest.Type != "" {
+			r.FedoraDigest = fmt.Sprintf("%s:%s", v.ContentDigest.Type, v.ContentDigest.Digest)
+			algo, supported := fedoraDigests[v.ContentDigest.Type]
+			switch {
+			case v.ContentDigest.Type == "DISABLED":
+				r.FedoraFixity = fixityDisabled
+			case !supported:
+				r.FedoraFixity = fixityUnsupported
+			default:
+				fedoraAlgo = algo
+				if !strInSlice(algo, algorithms) {
+					algorithms = append(append([]string{}, algorithms...), algo)
+				}
+			}
+		}
+
+		r.Size, r.Checksums, err = checksum(r.Path, algorithms)
+		if err != nil {
+			r.Error = err.Error()
+			r.FedoraFixity = ""
+		} else if fedoraAlgo != "" {
+			r.FedoraFixity = fixityMismatch
+			if strings.EqualFold(r.Checksums[fedoraAlgo], v.ContentDigest.Digest) {
+				r.FedoraFixity = fixityOk
+			}
+		}
+		reports = append(reports, r)
+	}
+
+	if len(reports) == 0 {
+		return []fileReport{{Pid: pid, Error: "no datastreams to audit"}}
+	}
+
+	return reports
+}
+
+// checksum a file with every algorithm in a single read
+func checksum(path string, algorithms []string) (int64, map[string]string, error) {
+	sums := map[string]string{}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, sums, err
+	}
+	defer file.Close()
+
+	hashers := map[string]hash.Hash{}
+	writers := []io.Writer{}
+	for _, algo := range algorithms {
+		h := hashes[algo]()
+		hashers[algo] = h
+		writers = append(writers, h)
+	}
+
+	size, err := io.Copy(io.MultiWriter(writers...), file)
+	if err != nil {
+		return size, sums, err
+	}
+	for algo, h := range hashers {
+		sums[algo] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	return size, sums, nil
+}
+
+// writeTsv starts every line with pid, checksums and path
+// so with the default -algorithms sha1 the first three columns
+// match what sha1.php used to write
+func writeTsv(w io.Writer, reports []fileReport, algorithms []string) error {
+	writer := csv.NewWriter(w)
+	writer.Comma = '\t'
+
+	header := []string{"pid"}
+	header = append(header, algorithms...)
+	header = append(header, "path", "dsid", "media_use", "version", "mime_type", "size", "fedora_digest", "fedora_fixity", "in_i2", "checksum_match", "error")
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, r := range reports {
+		record := []string{r.Pid}
+		for _, algo := range algorithms {
+			record = append(record, r.Checksums[algo])
+		}
+		size := ""
+		if r.Error == "" {
+			size = fmt.Sprint(r.Size)
+		}
+		record = append(record, r.Path, r.Dsid, r.MediaUse, r.Version, r.MimeType, size, r.FedoraDigest, r.FedoraFixity, r.InI2, r.ChecksumMatch, r.Error)
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeUnverifiable lists the files whose bytes can't be checked
+// against what fedora recorded at ingest
+func writeUnverifiable(f string, reports []fileReport) error {
+	file, err := os.Create(f)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Comma = '\t'
+	writer.Write([]string{"pid", "dsid", "version", "fedora_digest", "fedora_fixity", "path"})
+	for _, r := range reports {
+		switch r.FedoraFixity {
+		case fixityDisabled, fixityNone, fixityUnsupported:
+			writer.Write([]string{r.Pid, r.Dsid, r.Version, r.FedoraDigest, r.FedoraFixity, r.Path})
+		}
+	}
+	writer.Flush()
+
+	return writer.Error()
+}
+
+func strInSlice(e string, s []string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	statusMatch         = "match"
+	statusMismatch      = "mismatch"
+	statusMissingInI2   = "missing-in-i2"
+	statusMissingInI7   = "missing-in-i7"
+	statusMultipleFiles = "multiple-files"
+)
+
+// reconciliation is the outcome for a single PID and media use
+type reconciliation struct {
+	Pid      string
+	MediaUse string
+	Status   string
+	I7Dsids  []string
+	I7Sums   []string
+	I7Fixity []string
+	I2Sums   []string
+	Notes    []string
+}
+
+func reconcile(args []string) {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	i7Path := fs.String("i7", "i7.tsv", "report written by audit, TSV or JSON")
+	i2Path := fs.String("i2", "i2.tsv", "TSV of pid, media use and sha1 exported from i2")
+	outputPath := fs.String("o", "reconcile.csv", "CSV with a row per PID and media use")
+	summaryPath := fs.String("summary", "summary.csv", "CSV with the number of PIDs per status and media use")
+	fs.Parse(args)
+
+	i7, err := readReport(*i7Path)
+	if err != nil {
+		log.Fatalf("Unable to read %s: %v", *i7Path, err)
+	}
+	i2, err := readI2Files(*i2Path)
+	if err != nil {
+		log.Fatalf("Unable to read %s: %v", *i2Path, err)
+	}
+
+	type key struct {
+		pid      string
+		mediaUse string
+	}
+	rows := map[key]*reconciliation{}
+	row := func(pid, mediaUse string) *reconciliation {
+		k := key{pid, mediaUse}
+		if rows[k] == nil {
+			rows[k] = &reconciliation{Pid: pid, MediaUse: mediaUse}
+		}
+		return rows[k]
+	}
+
+	for _, r := range i7 {
+		if r.MediaUse == "" {
+			continue
+		}
+		rec := row(r.Pid, r.MediaUse)
+		if r.Error != "" {
+			rec.Notes = append(rec.Notes, fmt.Sprintf("%s: %s", r.Dsid, r.Error))
+			continue
+		}
+		rec.I7Dsids = append(rec.I7Dsids, r.Dsid)
+		rec.I7Sums = append(rec.I7Sums, r.Checksums["sha1"])
+		rec.I7Fixity = append(rec.I7Fixity, r.FedoraFixity)
+	}
+	for pid, mediaUses := range i2 {
+		for mediaUse, sums := range mediaUses {
+			rec := row(pid, mediaUse)
+			rec.I2Sums = append(rec.I2Sums, sums...)
+		}
+	}
+
+	reconciliations := []*reconciliation{}
+	for _, rec := range rows {
+		rec.Status = classify(rec.I7Sums, rec.I2Sums)
+		reconciliations = append(reconciliations, rec)
+	}
+	sort.Slice(reconciliations, func(i, j int) bool {
+		if reconciliations[i].Pid != reconciliations[j].Pid {
+			return reconciliations[i].Pid < reconciliations[j].Pid
+		}
+		return reconciliations[i].MediaUse < reconciliations[j].MediaUse
+	})
+
+	if err := writeReconciliations(*outputPath, reconciliations); err != nil {
+		log.Fatalf("Error writing %s: %v", *outputPath, err)
+	}
+	if err := writeSummary(*summaryPath, reconciliations); err != nil {
+		log.Fatalf("Error writing %s: %v", *summaryPath, err)
+	}
+
+	fmt.Println("Reconciliation complete. Output written to", *outputPath, "and", *summaryPath)
+}
+
+func classify(i7, i2 []string) string {
+	switch {
+	case len(i7) == 0:
+		return statusMissingInI7
+	case len(i2) == 0:
+		return statusMissingInI2
+	}
+
+	a := append([]string{}, i7...)
+	b := append([]string{}, i2...)
+	sort.Strings(a)
+	sort.Strings(b)
+	same := strings.Join(a, " ") == strings.Join(b, " ")
+
+	switch {
+	case same:
+		return statusMatch
+	case len(a) > 1 || len(b) > 1:
+		return statusMultipleFiles
+	}
+
+	return statusMismatch
+}
+
+// readReport reads the report written by audit
+func readReport(f string) ([]fileReport, error) {
+	reports := []fileReport{}
+	if strings.HasSuffix(f, ".json") {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(data, &reports)
+		return reports, err
+	}
+
+	file, err := os.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comma = '\t'
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range []string{"pid", "sha1", "dsid", "media_use", "error"} {
+		if _, found := columns[name]; !found {
+			return nil, fmt.Errorf("missing %s column, audit with -algorithms sha1", name)
+		}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		r := fileReport{
+			Pid:       record[columns["pid"]],
+			Dsid:      record[columns["dsid"]],
+			MediaUse:  record[columns["media_use"]],
+			Checksums: map[string]string{"sha1": record[columns["sha1"]]},
+			Error:     record[columns["error"]],
+		}
+		// reports from before fedora digests were checked don't have it
+		if i, found := columns["fedora_fixity"]; found {
+			r.FedoraFixity = record[i]
+		}
+		reports = append(reports, r)
+	}
+
+	return reports, nil
+}
+
+func writeReconciliations(f string, reconciliations []*reconciliation) error {
+	file, err := os.Create(f)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"pid", "media_use", "status", "i7_dsid", "i7_sha1", "i7_fedora_fixity", "i2_sha1", "notes"})
+	for _, rec := range reconciliations {
+		writer.Write([]string{
+			rec.Pid,
+			rec.MediaUse,
+			rec.Status,
+			strings.Join(rec.I7Dsids, "|"),
+			strings.Join(rec.I7Sums, "|"),
+			strings.Join(rec.I7Fixity, "|"),
+			strings.Join(rec.I2Sums, "|"),
+			strings.Join(rec.Notes, "; "),
+		})
+	}
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// writeSummary counts the reconciliations by status and media use
+// and prints the totals per status
+func writeSummary(f string, reconciliations []*reconciliation) error {
+	statuses := []string{statusMatch, statusMismatch, statusMissingInI2, statusMissingInI7, statusMultipleFiles}
+	counts := map[string]map[string]int{}
+	mediaUses := []string{}
+	for _, rec := range reconciliations {
+		if counts[rec.MediaUse] == nil {
+			counts[rec.MediaUse] = map[string]int{}
+			mediaUses = append(mediaUses, rec.MediaUse)
+		}
+		counts[rec.MediaUse][rec.Status]++
+	}
+	sort.Strings(mediaUses)
+
+	file, err := os.Create(f)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write(append([]string{"media_use"}, statuses...))
+	totals := map[string]int{}
+	for _, mediaUse := range mediaUses {
+		record := []string{mediaUse}
+		for _, status := range statuses {
+			record = append(record, fmt.Sprint(counts[mediaUse][status]))
+			totals[status] += counts[mediaUse][status]
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+
+	for _, status := range statuses {
+		fmt.Printf("%-16s %d\n", status, totals[status])
+	}
+
+	return writer.Error()
+}
@@ -123,7 +123,7 @@ def process_xml_folders(folders):
 
 
 folders = [
-    "../001-extract-mods/xml/digitalcollections",
-    "../001-extract-mods/xml/preserve",
+    "../001-extract-mods/xml/digitalcollections/MODS",
+    "../001-extract-mods/xml/preserve/MODS",
 ]
 process_xml_folders(folders)
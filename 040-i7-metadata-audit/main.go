@@ -2,7 +2,6 @@ package main
 
 import (
 	"encoding/csv"
-	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"html"
@@ -13,127 +12,13 @@ import (
 	"path/filepath"
 	"reflect"
 	"regexp"
-	"strconv"
 	"strings"
 	"sync"
 	"unicode"
 	"unicode/utf8"
-)
-
-type GettyResponse struct {
-	Label string `json:"_label"`
-}
-
-type Mods struct {
-	XMLName                       xml.Name  `xml:"mods"`
-	TitleInfo                     []Element `xml:"titleInfo>title"`
-	Names                         []Element `xml:"name"`
-	Abstract                      []Element `xml:"abstract"`
-	AccessCondition               []Element `xml:"accessCondition"`
-	Classification                []Element `xml:"classification"`
-	Genre                         []Element `xml:"genre"`
-	Identifier                    []Element `xml:"identifier"`
-	Language                      []Element `xml:"language>languageTerm"`
-	PhysicalLocation              []Element `xml:"location>physicalLocation"`
-	Note                          []Element `xml:"note"`
-	DateCaptured                  []Element `xml:"originInfo>dateCaptured"`
-	DateCreated                   []Element `xml:"originInfo>dateCreated"`
-	DateIssued                    []Element `xml:"originInfo>dateIssued"`
-	DateOther                     []Element `xml:"originInfo>dateOther"`
-	DateValid                     []Element `xml:"originInfo>dateValid"`
-	Edition                       []Element `xml:"originInfo>edition"`
-	Issuance                      []Element `xml:"originInfo>issuance"`
-	Place                         []Element `xml:"originInfo>place>placeTerm"`
-	Publisher                     []Element `xml:"originInfo>publisher"`
-	Extent                        []Element `xml:"physicalDescription>extent"`
-	Form                          []Element `xml:"physicalDescription>form"`
-	InternetMediaType             []Element `xml:"physicalDescription>internetMediaType"`
-	Origin                        []Element `xml:"physicalDescription>digitalOrigin"`
-	PhysicalDescription           []Element `xml:"physicalDescription>note"`
-	RecordOrigin                  []Element `xml:"recordInfo>recordOrigin"`
-	RelatedItem                   []Element `xml:"relatedItem"`
-	ResourceType                  []Element `xml:"typeOfResource"`
-	Subject                       []Element `xml:"subject"`
-	TableOfContents               []Element `xml:"tableOfContents"`
-	PartDetail                    []Element `xml:"part"`
-	SubjectGeographic             []Element
-	SubjectGeographicHierarchical []Element
-	SubjectName                   []Element
-	SubjectLcsh                   []Element
-	AltTitle                      []Element
-	TitlePartName                 []Element
-}
-
-type Element struct {
-	Authority              string                 `xml:"authority,attr"`
-	Type                   string                 `xml:"type,attr"`
-	Point                  string                 `xml:"point,attr"`
-	Unit                   string                 `xml:"unit,attr"`
-	Value                  string                 `xml:",innerxml"`
-	Identifier             string                 `xml:"identifier"`
-	Number                 string                 `xml:"part>detail>number"`
-	Title                  string                 `xml:"title"`
-	TitleInfo              string                 `xml:"titleInfo>title"`
-	NamePart               string                 `xml:"namePart"`
-	Role                   []Element              `xml:"role>roleTerm"`
-	Geographic             SubElement             `xml:"geographic"`
-	SubjectName            string                 `xml:"name>namePart"`
-	Topic                  string                 `xml:"topic"`
-	HierarchicalGeographic HierarchicalGeographic `xml:"hierarchicalGeographic"`
-	Note                   string                 `xml:"note"`
-	Language               string                 `xml:"languageTerm"`
-	DateCaptured           string                 `xml:"dateCaptured"`
-	DateCreated            string                 `xml:"dateCreated"`
-	DateIssued             string                 `xml:"dateIssued"`
-	DateOther              SubElement             `xml:"dateOther"`
-	DateValid              string                 `xml:"dateValid"`
-	Edition                string                 `xml:"edition"`
-	Issuance               string                 `xml:"issuance"`
-	Place                  string                 `xml:"place>placeTerm"`
-	Publisher              string                 `xml:"publisher"`
-	Extent                 string                 `xml:"extent"`
-	Form                   string                 `xml:"form"`
-	InternetMediaType      string                 `xml:"internetMediaType"`
-	Origin                 string                 `xml:"digitalOrigin"`
-	RecordOrigin           string                 `xml:"recordOrigin"`
-	PhysicalLocation       string                 `xml:"physicalLocation"`
-	PartName               string                 `xml:"partName"`
-	PartDetail             []PartDetail           `xml:"detail"`
-}
-
-type SubElement struct {
-	Authority string `xml:"authority,attr"`
-	Type      string `xml:"type,attr"`
-	Value     string `xml:",innerxml"`
-}
-
-type HierarchicalGeographic struct {
-	City      string `xml:"city" json:"city,omitempty"`
-	Continent string `xml:"continent" json:"continent,omitempty"`
-	Country   string `xml:"country" json:"country,omitempty"`
-	County    string `xml:"county" json:"county,omitempty"`
-	State     string `xml:"state" json:"state,omitempty"`
-	Territory string `xml:"territory" json:"territory,omitempty"`
-}
-
-type RelatedItem struct {
-	Identifier string `json:"identifier,omitempty"`
-	Title      string `json:"title,omitempty"`
-	Number     string `json:"number,omitempty"`
-}
 
-type TypedText struct {
-	Attr0 string `json:"attr0,omitempty"`
-	Attr1 string `json:"attr1,omitempty"`
-	Value string `json:"value"`
-}
-
-type PartDetail struct {
-	Type    string `xml:"type,attr" json:"type,omitempty"`
-	Caption string `xml:"caption" json:"caption,omitempty"`
-	Number  string `xml:"number" json:"number,omitempty"`
-	Title   string `xml:"title" json:"title,omitempty"`
-}
+	"github.com/lehigh-university-libraries/i7-audit/mods"
+)
 
 var (
 	pids = map[string]string{}
@@ -223,6 +108,13 @@ func main() {
 		return
 	}
 	dir = filepath.Clean(dir)
+	// i2 has the labels of getty forms, so they're always looked up
+	// GETTY_CACHE remembers them for later runs, otherwise only for this one
+	getty, err := mods.NewGettyLookup(os.Getenv("GETTY_CACHE"))
+	if err != nil {
+		log.Fatalf("Unable to read %s: %v", os.Getenv("GETTY_CACHE"), err)
+	}
+	mods.Getty = getty
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		fmt.Printf("Directory %s does not exist.\n", dir)
 		return
@@ -259,7 +151,9 @@ func main() {
 			fmt.Printf("Error accessing %s: %v\n", path, err)
 			return err
 		}
-		if !info.IsDir() {
+		// 001-extract-mods saves every datastream under xml/<namespace>/<DSID>/
+		// only the MODS are of interest here
+		if !info.IsDir() && filepath.Base(filepath.Dir(path)) == "MODS" {
 			ch <- fileInfo{Path: path, Info: info}
 		}
 
@@ -306,7 +200,7 @@ func worker(writer *csv.Writer, header []string) {
 		}
 
 		// compare i7 vs i2
-		var i7, i2 Mods
+		var i7, i2 mods.Mods
 		xml.Unmarshal(i7Mods, &i7)
 		xml.Unmarshal(i2Mods, &i2)
 
@@ -339,7 +233,7 @@ func writeToCSV(writer *csv.Writer, row []string) error {
 	return nil
 }
 
-func modsMatch(pid string, m1, m2 Mods) map[string][]string {
+func modsMatch(pid string, m1, m2 mods.Mods) map[string][]string {
 	row := map[string][]string{
 		"node_id": []string{pids[pid]},
 	}
@@ -348,8 +242,8 @@ func modsMatch(pid string, m1, m2 Mods) map[string][]string {
 	mismatch := false
 	for drupalField, fieldName := range fieldsToAccess {
 		row[drupalField] = []string{}
-		i7Elements := reflect.Indirect(i7).FieldByName(fieldName).Interface().([]Element)
-		i2Elements := reflect.Indirect(i2).FieldByName(fieldName).Interface().([]Element)
+		i7Elements := reflect.Indirect(i7).FieldByName(fieldName).Interface().([]mods.Element)
+		i2Elements := reflect.Indirect(i2).FieldByName(fieldName).Interface().([]mods.Element)
 
 		for k, e1 := range i7Elements {
 			if len(i2Elements) < k+1 {
@@ -453,333 +347,6 @@ func areStringsEqualIgnoringSpecialChars(s1, s2 string) bool {
 	return true
 }
 
-func (m *Mods) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
-	type modAlias Mods
-
-	for {
-		token, err := d.Token()
-		if err != nil {
-			return err
-		}
-		switch t := token.(type) {
-		case xml.StartElement:
-			switch t.Name.Local {
-			case "mods":
-				var alias modAlias
-				if err := d.DecodeElement(&alias, &t); err != nil {
-					return err
-				}
-				*m = Mods(alias)
-			case "relatedItem":
-				var e1 Element
-				if err := d.DecodeElement(&e1, &t); err != nil {
-					return err
-				}
-				var e Element
-				xml.Unmarshal([]byte(e1.Value), &e)
-
-				if e.Title == "" && e.Identifier == "" && e.Number == "" {
-					continue
-				}
-				ri := RelatedItem{
-					Title:      e.Title,
-					Identifier: e.Identifier,
-					Number:     e.Number,
-				}
-
-				jsonData, err := json.Marshal(ri)
-				if err != nil {
-					fmt.Println("Error marshaling JSON:", err)
-					return err
-				}
-
-				e.Value = string(jsonData)
-				m.RelatedItem = append(m.RelatedItem, e)
-
-			case "name":
-				var e Element
-				if err := d.DecodeElement(&e, &t); err != nil {
-					return err
-				}
-				if e.NamePart == "" {
-					continue
-				}
-				vocab := "person"
-				relator := "relators:att"
-				if e.Type == "corporate" {
-					vocab = "corporate_body"
-				}
-				for _, r := range e.Role {
-					if r.Type == "code" {
-						relator = fmt.Sprintf("relators:%s", r.Value)
-						break
-					} else if r.Value == "Department" {
-						relator = "label:department"
-						break
-					}
-				}
-				e.Value = fmt.Sprintf("%s:%s:%s", relator, vocab, e.NamePart)
-				m.Names = append(m.Names, e)
-			case "subject":
-				var e Element
-				if err := d.DecodeElement(&e, &t); err != nil {
-					return err
-				}
-				if e.Topic != "" {
-					e.Value = e.Topic
-					if _, err := strconv.Atoi(e.Value); err == nil {
-						e.Value = fmt.Sprintf("workbench-number-%s", e.Value)
-					}
-					if e.Authority == "lcsh" {
-						m.SubjectLcsh = append(m.SubjectLcsh, e)
-					} else if e.Authority != "" {
-						log.Println(e.Authority)
-					} else {
-						m.Subject = append(m.Subject, e)
-					}
-				} else if e.Geographic.Value != "" {
-					vid := "geo_location"
-					if e.Geographic.Authority == "naf" {
-						vid = "geographic_naf"
-					} else if e.Geographic.Authority == "local" {
-						vid = "geographic_local"
-					}
-					if _, err := strconv.Atoi(e.Geographic.Value); err == nil {
-						e.Geographic.Value = fmt.Sprintf("workbench-number-%s", e.Geographic.Value)
-					}
-					e.Value = fmt.Sprintf("%s:%s", vid, e.Geographic.Value)
-					m.SubjectGeographic = append(m.SubjectGeographic, e)
-				} else if e.SubjectName != "" {
-					if _, err := strconv.Atoi(e.SubjectName); err == nil {
-						e.SubjectName = fmt.Sprintf("workbench-number-%s", e.SubjectName)
-					}
-					snVocab := "corporate_body"
-					if strings.Contains(e.Value, ",") {
-						snVocab = "person"
-					}
-
-					e.Value = fmt.Sprintf("%s:%s", snVocab, e.SubjectName)
-					m.SubjectName = append(m.SubjectName, e)
-				} else if !e.HierarchicalGeographic.Empty() {
-					e.Value, err = e.HierarchicalGeographic.Json()
-					if err != nil {
-						log.Println("Failed to unmarshal hierarchicalGeographic")
-						return fmt.Errorf("Failed to marshal hierarchical geographic as JSON")
-					}
-					m.SubjectGeographicHierarchical = append(m.SubjectGeographicHierarchical, e)
-				} else {
-					continue
-				}
-			case "abstract", "identifier", "note":
-				var e Element
-				if err := d.DecodeElement(&e, &t); err != nil {
-					return err
-				}
-
-				if e.Value == "" {
-					continue
-				}
-
-				tt := TypedText{
-					Attr0: e.Type,
-					Attr1: e.Point,
-					Value: e.Value,
-				}
-				jsonData, err := json.Marshal(tt)
-				if err != nil {
-					fmt.Println("Error marshaling JSON:", err)
-					return err
-				}
-
-				e.Value = string(jsonData)
-				switch t.Name.Local {
-				case "abstract":
-					m.Abstract = append(m.Abstract, e)
-				case "identifier":
-					m.Identifier = append(m.Identifier, e)
-				case "note":
-					m.Note = append(m.Note, e)
-				}
-			default:
-				e := Element{}
-				if err := d.DecodeElement(&e, &t); err != nil {
-					return err
-				}
-				switch t.Name.Local {
-				case "accessCondition":
-					if e.Value != "" {
-						m.AccessCondition = append(m.AccessCondition, e)
-					}
-				case "classification":
-					if e.Value != "" {
-						m.Classification = append(m.Classification, e)
-					}
-				case "genre":
-					if e.Value != "" {
-						m.Genre = append(m.Genre, e)
-					}
-				case "language":
-					if e.Language != "" {
-						e.Value = e.Language
-						m.Language = append(m.Language, e)
-					}
-				case "location":
-					if e.PhysicalLocation != "" {
-						e.Value = e.PhysicalLocation
-						m.PhysicalLocation = append(m.PhysicalLocation, e)
-					}
-				case "originInfo":
-					if e.DateCaptured != "" {
-						e.Value = e.DateCaptured
-						m.DateCaptured = append(m.DateCaptured, e)
-					}
-					if e.DateCreated != "" {
-						e.Value = e.DateCreated
-						m.DateCreated = append(m.DateCreated, e)
-					}
-					if e.DateIssued != "" {
-						e.Value = e.DateIssued
-						m.DateIssued = append(m.DateIssued, e)
-					}
-					if e.DateOther.Value != "" {
-						tt := TypedText{
-							Attr0: e.DateOther.Type,
-							Value: e.DateOther.Value,
-						}
-						jsonData, err := json.Marshal(tt)
-						if err != nil {
-							fmt.Println("Error marshaling JSON:", err)
-							return err
-						}
-						e.Value = string(jsonData)
-						m.DateOther = append(m.DateOther, e)
-					}
-					if e.DateValid != "" {
-						e.Value = e.DateValid
-						m.DateValid = append(m.DateValid, e)
-					}
-					if e.Place != "" {
-						e.Value = e.Place
-						m.Place = append(m.Place, e)
-					}
-					if e.Publisher != "" {
-						e.Value = fmt.Sprintf("relators:pbl:corporate_body:%s", e.Publisher)
-						m.Names = append(m.Names, e)
-					}
-					if e.Edition != "" {
-						e.Value = e.Edition
-						m.Edition = append(m.Edition, e)
-					}
-					if e.Issuance != "" {
-						e.Value = e.Issuance
-						m.Issuance = append(m.Issuance, e)
-					}
-
-				case "physicalDescription":
-					if e.Extent != "" {
-						tt := TypedText{
-							Value: e.Extent,
-							Attr0: e.Unit,
-						}
-						jsonData, err := json.Marshal(tt)
-						if err != nil {
-							fmt.Println("Error marshaling JSON:", err)
-							return err
-						}
-						e.Value = string(jsonData)
-						m.Extent = append(m.Extent, e)
-					}
-					if e.Form != "" {
-						e.Value = getFormValue(e.Form)
-
-						m.Form = append(m.Form, e)
-					}
-					if e.InternetMediaType != "" {
-						e.Value = e.InternetMediaType
-						m.InternetMediaType = append(m.InternetMediaType, e)
-					}
-					if e.Origin != "" {
-						e.Value = e.Origin
-						m.Origin = append(m.Origin, e)
-					}
-					if e.Note != "" {
-						ttNote := TypedText{
-							Value: e.Note,
-							Attr0: e.Type,
-						}
-						jsonDataNote, err := json.Marshal(ttNote)
-						if err != nil {
-							fmt.Println("Error marshaling JSON:", err)
-							return err
-						}
-						e.Value = string(jsonDataNote)
-						m.PhysicalDescription = append(m.PhysicalDescription, e)
-					}
-				case "recordInfo":
-					if e.RecordOrigin != "" {
-						e.Value = e.RecordOrigin
-						m.RecordOrigin = append(m.RecordOrigin, e)
-					}
-				case "titleInfo":
-					if e.Type == "alternative" && e.Title != "" {
-						e.Value = e.Title
-						m.AltTitle = append(m.AltTitle, e)
-					}
-					if e.PartName != "" {
-						e.Value = e.PartName
-						m.TitlePartName = append(m.TitlePartName, e)
-					}
-				case "typeOfResource":
-					if e.Value != "" {
-						m.ResourceType = append(m.ResourceType, e)
-					}
-				case "tableOfContents":
-					if e.Value != "" {
-						m.TableOfContents = append(m.TableOfContents, e)
-					}
-				case "part":
-					for _, d := range e.PartDetail {
-						if d.Caption == "" && d.Number == "" && d.Title == "" {
-							continue
-						}
-						pd := PartDetail{
-							Type:    d.Type,
-							Caption: d.Caption,
-							Number:  d.Number,
-							Title:   d.Title,
-						}
-						pdj, err := json.Marshal(pd)
-						if err != nil {
-							fmt.Println("Error marshaling JSON:", err)
-							return err
-						}
-						e.Value = string(pdj)
-						m.PartDetail = append(m.PartDetail, e)
-					}
-				}
-			}
-		case xml.EndElement:
-			if t == start.End() {
-				return nil
-			}
-		}
-	}
-}
-
-func (hg *HierarchicalGeographic) Empty() bool {
-	return hg.City == "" && hg.Continent == "" && hg.Country == "" && hg.County == "" && hg.State == "" && hg.Territory == ""
-}
-
-func (hg *HierarchicalGeographic) Json() (string, error) {
-	jsonData, err := json.Marshal(hg)
-	if err != nil {
-		fmt.Println("Error marshaling JSON:", err)
-		return "", err
-	}
-
-	return string(jsonData), nil
-}
-
 func strInMap(e string, s []string) bool {
 	for _, a := range s {
 		if a == e {
@@ -788,38 +355,3 @@ func strInMap(e string, s []string) bool {
 	}
 	return false
 }
-
-func getFormValue(s string) string {
-	// Find the numeric part from the URL using the regular expression
-	re := regexp.MustCompile(`http://vocab.getty.edu/page/aat/(\d+)`)
-	matches := re.FindStringSubmatch(s)
-	if len(matches) < 2 {
-		return s
-	}
-	numericPart := matches[1]
-
-	jsonURL := fmt.Sprintf("https://vocab.getty.edu/aat/%s.json", numericPart)
-	req, err := http.NewRequest("GET", jsonURL, nil)
-	if err != nil {
-		fmt.Println("Error:", err)
-		return s
-	}
-	req.Header.Set("Accept", "application/json")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return s
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return s
-	}
-	var r GettyResponse
-	err = json.Unmarshal(body, &r)
-	if err != nil {
-		return s
-	}
-
-	return r.Label
-}
@@ -0,0 +1,207 @@
+// Package akubra reads fedora 3 objects and datastreams straight out of
+// the akubra object and datastream stores on disk
+package akubra
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrMissing is returned when an object, datastream or its content isn't in the stores
+var ErrMissing = errors.New("not found")
+
+// FOXML as stored in fedora's object store
+type FoxmlObject struct {
+	XMLName     xml.Name          `xml:"digitalObject"`
+	Pid         string            `xml:"PID,attr"`
+	Datastreams []FoxmlDatastream `xml:"datastream"`
+}
+
+type FoxmlDatastream struct {
+	ID           string         `xml:"ID,attr"`
+	State        string         `xml:"STATE,attr"`
+	ControlGroup string         `xml:"CONTROL_GROUP,attr"`
+	Versions     []FoxmlVersion `xml:"datastreamVersion"`
+}
+
+type FoxmlVersion struct {
+	ID              string                `xml:"ID,attr"`
+	Label           string                `xml:"LABEL,attr"`
+	Created         string                `xml:"CREATED,attr"`
+	MimeType        string                `xml:"MIMETYPE,attr"`
+	Size            int64                 `xml:"SIZE,attr"`
+	ContentDigest   *FoxmlContentDigest   `xml:"contentDigest"`
+	ContentLocation *FoxmlContentLocation `xml:"contentLocation"`
+	XMLContent      *FoxmlXMLContent      `xml:"xmlContent"`
+}
+
+type FoxmlContentDigest struct {
+	Type   string `xml:"TYPE,attr"`
+	Digest string `xml:"DIGEST,attr"`
+}
+
+type FoxmlContentLocation struct {
+	Type string `xml:"TYPE,attr"`
+	Ref  string `xml:"REF,attr"`
+}
+
+type FoxmlXMLContent struct {
+	Value []byte `xml:",innerxml"`
+}
+
+// Store is one of fedora's HashPathIdMapper backed stores on disk
+type Store struct {
+	Path string
+	// i.e. "##" for one level of two hex characters, "##/##" for two of them
+	Pattern string
+}
+
+// Repository is a fedora 3 object store and its datastream store
+type Repository struct {
+	Objects     Store
+	Datastreams Store
+}
+
+// NewRepository is a repository using fedora's default "##" pattern for both stores
+func NewRepository(objectStore, datastreamStore string) Repository {
+	return Repository{
+		Objects:     Store{Path: objectStore, Pattern: "##"},
+		Datastreams: Store{Path: datastreamStore, Pattern: "##"},
+	}
+}
+
+// from https://github.com/discoverygarden/akubra_adapter/blob/e887885abc4d1f9fd5df47d072105f447e7e67fe/src/Utility/Fedora3/AkubraLowLevelAdapter.php#L37-L58
+// @see https://github.com/fcrepo3/fcrepo/blob/37df51b9b857fd12c6ab8269820d406c3c4ad774/fcrepo-server/src/main/java/org/fcrepo/server/storage/lowlevel/akubra/HashPathIdMapper.java#L17-L68
+func (s Store) Dereference(id string) string {
+	// Structure like: "the:pid+DSID+DSID.0"
+	// Need: "{base_path}/{hash_pattern}/{id}".
+	full := "info:fedora/" + strings.ReplaceAll(id, "+", "/")
+	sum := md5.Sum([]byte(full))
+	hash := hex.EncodeToString(sum[:])
+
+	dirs := []string{s.Path}
+	offset := 0
+	for _, level := range strings.Split(s.Pattern, "/") {
+		dirs = append(dirs, hash[offset:offset+len(level)])
+		offset += len(level)
+	}
+	dirs = append(dirs, rawurlencode(full))
+
+	return filepath.Join(dirs...)
+}
+
+// rawurlencode matches PHP's rawurlencode, plus "_" which akubra also encodes
+func rawurlencode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+
+	return b.String()
+}
+
+// Foxml is the object's FOXML as it is on disk
+func (r Repository) Foxml(pid string) ([]byte, error) {
+	data, err := os.ReadFile(r.Objects.Dereference(pid))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: no FOXML for %s", ErrMissing, pid)
+	}
+
+	return data, err
+}
+
+// Object is the object's parsed FOXML
+func (r Repository) Object(pid string) (FoxmlObject, error) {
+	var o FoxmlObject
+
+	data, err := r.Foxml(pid)
+	if err != nil {
+		return o, err
+	}
+	err = xml.Unmarshal(data, &o)
+
+	return o, err
+}
+
+// Datastream finds one of the object's datastreams by ID
+func (o FoxmlObject) Datastream(dsid string) (FoxmlDatastream, bool) {
+	for _, ds := range o.Datastreams {
+		if ds.ID == dsid {
+			return ds, true
+		}
+	}
+
+	return FoxmlDatastream{}, false
+}
+
+// Latest is the last version listed, same as fedora
+func (ds FoxmlDatastream) Latest() (FoxmlVersion, bool) {
+	if len(ds.Versions) == 0 {
+		return FoxmlVersion{}, false
+	}
+
+	return ds.Versions[len(ds.Versions)-1], true
+}
+
+// ContentPath is where a managed datastream version's bytes live on disk
+func (r Repository) ContentPath(v FoxmlVersion) (string, error) {
+	if v.ContentLocation == nil || v.ContentLocation.Ref == "" {
+		return "", fmt.Errorf("%w: datastream version %s has no content location", ErrMissing, v.ID)
+	}
+	if v.ContentLocation.Type != "INTERNAL_ID" {
+		return "", fmt.Errorf("datastream version %s is stored outside fedora at %s", v.ID, v.ContentLocation.Ref)
+	}
+
+	return r.Datastreams.Dereference(v.ContentLocation.Ref), nil
+}
+
+// Content of the latest version of a datastream
+// FOXML returns the object itself
+func (r Repository) Content(pid, dsid string) ([]byte, error) {
+	if dsid == "FOXML" {
+		return r.Foxml(pid)
+	}
+
+	o, err := r.Object(pid)
+	if err != nil {
+		return nil, err
+	}
+	ds, found := o.Datastream(dsid)
+	if !found {
+		return nil, fmt.Errorf("%w: %s has no %s", ErrMissing, pid, dsid)
+	}
+	v, found := ds.Latest()
+	if !found {
+		return nil, fmt.Errorf("%w: %s %s has no versions", ErrMissing, pid, dsid)
+	}
+
+	// inline XML lives in the FOXML itself
+	if ds.ControlGroup == "X" {
+		if v.XMLContent == nil {
+			return nil, fmt.Errorf("%w: %s %s has no inline XML", ErrMissing, pid, dsid)
+		}
+		return []byte(strings.TrimSpace(string(v.XMLContent.Value))), nil
+	}
+
+	path, err := r.ContentPath(v)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s is missing from the datastream store", ErrMissing, path)
+	}
+
+	return data, err
+}
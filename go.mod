@@ -0,0 +1,3 @@
+module github.com/lehigh-university-libraries/i7-audit
+
+go 1.22
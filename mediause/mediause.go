@@ -0,0 +1,49 @@
+// Package mediause maps i7 datastream IDs to the i2 media use their files were migrated to
+package mediause
+
+import (
+	_ "embed"
+	"encoding/json"
+	"os"
+)
+
+// the mapping used when no other file is given
+//
+//go:embed media-use.json
+var defaultMapping []byte
+
+// Use is an i2 media use term, by the name 030 compares i2's files by
+// and the URI workbench's media_use_tid takes
+type Use struct {
+	Name string `json:"name"`
+	URI  string `json:"uri"`
+}
+
+// UnmarshalJSON also takes a plain string, the name, like media-use.json used to have
+func (u *Use) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*u = Use{Name: name}
+		return nil
+	}
+
+	type use Use
+	return json.Unmarshal(data, (*use)(u))
+}
+
+// Load reads a DSID => media use mapping, or the default one when f is empty
+func Load(f string) (map[string]Use, error) {
+	data := defaultMapping
+	if f != "" {
+		var err error
+		data, err = os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	uses := map[string]Use{}
+	err := json.Unmarshal(data, &uses)
+
+	return uses, err
+}
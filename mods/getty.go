@@ -0,0 +1,155 @@
+package mods
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Getty looks up the labels of the getty AAT terms physicalDescription/form links to
+// when it's nil a form that's a URI is kept as the URI, so parsing never touches the network
+var Getty *GettyLookup
+
+var aatPage = regexp.MustCompile(`http://vocab.getty.edu/page/aat/(\d+)`)
+
+type GettyResponse struct {
+	Label string `json:"_label"`
+}
+
+// GettyLookup remembers every label it finds in a uri,label CSV,
+// so later runs don't have to ask again
+type GettyLookup struct {
+	client *http.Client
+	path   string
+	mu     sync.Mutex
+	labels map[string]string
+	failed map[string]error
+}
+
+// NewGettyLookup reads the labels an earlier run found from f, which doesn't have to exist yet
+// with no f labels are only remembered for this run
+func NewGettyLookup(f string) (*GettyLookup, error) {
+	g := &GettyLookup{
+		client: &http.Client{Timeout: 30 * time.Second},
+		path:   f,
+		labels: map[string]string{},
+		failed: map[string]error{},
+	}
+	if f == "" {
+		return g, nil
+	}
+
+	file, err := os.Open(f)
+	if errors.Is(err, os.ErrNotExist) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		g.labels[record[0]] = record[1]
+	}
+
+	return g, nil
+}
+
+// Label is the label of the AAT term at uri, asking getty once per run if it isn't cached
+func (g *GettyLookup) Label(uri string) (string, error) {
+	g.mu.Lock()
+	label, found := g.labels[uri]
+	err := g.failed[uri]
+	g.mu.Unlock()
+	if found || err != nil {
+		return label, err
+	}
+
+	label, err = g.fetch(uri)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err != nil {
+		// only said once, the form keeps the URI every time
+		fmt.Printf("Unable to look up %s, keeping the URI: %v\n", uri, err)
+		g.failed[uri] = err
+		return "", err
+	}
+	g.labels[uri] = label
+	if g.path == "" {
+		return label, nil
+	}
+	file, err := os.OpenFile(g.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Println("Error opening getty cache:", err)
+		return label, nil
+	}
+	defer file.Close()
+	w := csv.NewWriter(file)
+	w.Write([]string{uri, label})
+	w.Flush()
+
+	return label, nil
+}
+
+func (g *GettyLookup) fetch(uri string) (string, error) {
+	matches := aatPage.FindStringSubmatch(uri)
+	if len(matches) < 2 {
+		return "", fmt.Errorf("%s isn't a getty AAT page", uri)
+	}
+
+	jsonURL := fmt.Sprintf("https://vocab.getty.edu/aat/%s.json", matches[1])
+	req, err := http.NewRequest("GET", jsonURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", jsonURL, resp.Status)
+	}
+
+	var r GettyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return "", fmt.Errorf("unable to parse %s: %v", jsonURL, err)
+	}
+	if r.Label == "" {
+		return "", fmt.Errorf("no label in %s", jsonURL)
+	}
+
+	return r.Label, nil
+}
+
+// getFormValue is the label of a form that links to getty when Getty is on,
+// otherwise the form as it is
+func getFormValue(s string) string {
+	if Getty == nil || !aatPage.MatchString(s) {
+		return s
+	}
+	label, err := Getty.Label(s)
+	if err != nil {
+		return s
+	}
+
+	return label
+}
@@ -0,0 +1,41 @@
+// Package ratelimit spaces out requests so no one host is sent more than it can take
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// HostLimiter spaces out requests to the same host
+type HostLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     map[string]time.Time
+}
+
+// NewHostLimiter allows perSecond requests a second to each host, or any number if it's 0
+func NewHostLimiter(perSecond float64) *HostLimiter {
+	interval := time.Duration(0)
+	if perSecond > 0 {
+		interval = time.Duration(float64(time.Second) / perSecond)
+	}
+
+	return &HostLimiter{
+		interval: interval,
+		next:     map[string]time.Time{},
+	}
+}
+
+// Wait blocks until the next request to host is allowed
+func (l *HostLimiter) Wait(host string) {
+	l.mu.Lock()
+	now := time.Now()
+	t := l.next[host]
+	if t.Before(now) {
+		t = now
+	}
+	l.next[host] = t.Add(l.interval)
+	l.mu.Unlock()
+
+	time.Sleep(time.Until(t))
+}